@@ -0,0 +1,159 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// Authentication errors (are returned by the Authenticator implementations).
+var (
+	// ErrNoCredentials means that request does not contain credentials ("401 Unauthorized" is responded).
+	ErrNoCredentials = errors.New("credentials are required") //nolint:gochecknoglobals
+
+	// ErrInvalidCredentials means that request credentials are wrong ("401 Unauthorized" is responded).
+	ErrInvalidCredentials = errors.New("invalid credentials") //nolint:gochecknoglobals
+
+	// ErrAccessDenied means that authenticated user has no access ("403 Forbidden" is responded).
+	ErrAccessDenied = errors.New("access denied") //nolint:gochecknoglobals
+)
+
+// Authenticator checks the request credentials.
+type Authenticator interface {
+	// Authenticate returns authenticated user name. ErrNoCredentials, ErrInvalidCredentials and ErrAccessDenied
+	// errors (or errors, that wrap them) are responded with "401 Unauthorized" or "403 Forbidden", any other error is
+	// responded with "500 Internal Server Error".
+	Authenticate(r *http.Request) (user string, err error)
+
+	// Challenge returns `WWW-Authenticate` header value for the "401 Unauthorized" responses.
+	Challenge() string
+}
+
+// AuthRule describes authentication requirement for the URL paths, that match the pattern.
+type AuthRule struct {
+	// Path pattern (relative to the `Settings.BasePath`): regular expression (when it starts with `^`) or glob (`*`
+	// matches any characters sequence, including `/`).
+	Path string
+
+	// Authenticator for the matched paths (nil means public access, that can be used for the exceptions).
+	Authenticator Authenticator
+
+	// Users, that have access to the matched paths (any authenticated user has access, when empty).
+	Users []string
+}
+
+// authRule is a compiled AuthRule.
+type authRule struct {
+	path          *regexp.Regexp
+	authenticator Authenticator
+	users         map[string]struct{}
+}
+
+func compileAuthRule(rule AuthRule) (*authRule, error) {
+	pattern, err := compilePathPattern(rule.Path)
+	if err != nil {
+		return nil, fmt.Errorf("wrong pattern: %w", err)
+	}
+
+	compiled := &authRule{path: pattern, authenticator: rule.Authenticator}
+
+	if len(rule.Users) > 0 {
+		if rule.Authenticator == nil {
+			return nil, errors.New("users list requires authenticator")
+		}
+
+		compiled.users = make(map[string]struct{}, len(rule.Users))
+
+		for _, user := range rule.Users {
+			compiled.users[user] = struct{}{}
+		}
+	}
+
+	return compiled, nil
+}
+
+// compileAuthRules compiles (already validated) rules. Wrong rules are skipped.
+func compileAuthRules(rules []AuthRule) []*authRule {
+	result := make([]*authRule, 0, len(rules))
+
+	for _, rule := range rules {
+		if compiled, err := compileAuthRule(rule); err == nil {
+			result = append(result, compiled)
+		}
+	}
+
+	return result
+}
+
+// userContextKey is a request context key for the authenticated user name.
+type userContextKey struct{}
+
+// AuthenticatedUser returns user name, authenticated during request processing.
+func AuthenticatedUser(r *http.Request) (string, bool) {
+	user, ok := r.Context().Value(userContextKey{}).(string)
+
+	return user, ok
+}
+
+// authenticate checks access to the URL path using the first matched rule. Request with authenticated user (in
+// context) is returned on success, otherwise error response is written and false is returned.
+func (fs *FileServer) authenticate(
+	w http.ResponseWriter, r *http.Request, cfg *config, urlPath string,
+) (*http.Request, bool) {
+	for _, rule := range cfg.authRules {
+		if rule.path.MatchString(urlPath) {
+			return fs.checkAuthRule(w, r, rule)
+		}
+	}
+
+	return r, true
+}
+
+// checkAccess checks signed URL (see checkSignedURL) and authentication (see authenticate) for the URL path. Request
+// with authenticated user (in context) is returned on success, otherwise error response is written and false is
+// returned.
+func (fs *FileServer) checkAccess(
+	w http.ResponseWriter, r *http.Request, cfg *config, urlPath string,
+) (*http.Request, bool) {
+	if !fs.checkSignedURL(w, r, cfg, urlPath) {
+		return r, false
+	}
+
+	return fs.authenticate(w, r, cfg, urlPath)
+}
+
+// checkAuthRule checks the request credentials using the rule authenticator (path pattern is not checked). Request
+// with authenticated user (in context) is returned on success, otherwise error response is written and false is
+// returned.
+func (fs *FileServer) checkAuthRule(w http.ResponseWriter, r *http.Request, rule *authRule) (*http.Request, bool) {
+	if rule.authenticator == nil { // public access
+		return r, true
+	}
+
+	user, err := rule.authenticator.Authenticate(r)
+
+	if err == nil && rule.users != nil {
+		if _, ok := rule.users[user]; !ok {
+			err = ErrAccessDenied
+		}
+	}
+
+	switch {
+	case err == nil:
+		return r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)), true
+
+	case errors.Is(err, ErrNoCredentials), errors.Is(err, ErrInvalidCredentials):
+		w.Header().Set("WWW-Authenticate", rule.authenticator.Challenge())
+		fs.handleError(w, r, http.StatusUnauthorized)
+
+	case errors.Is(err, ErrAccessDenied):
+		fs.handleError(w, r, http.StatusForbidden)
+
+	default:
+		fs.handleError(w, r, http.StatusInternalServerError)
+	}
+
+	return r, false
+}
@@ -0,0 +1,223 @@
+package fileserver
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPasswordHash(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+
+	for _, hash := range []string{
+		string(bcryptHash),
+		"$2y$" + string(bcryptHash[4:]),
+		"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0", // openssl passwd -apr1 -salt saltsalt secret
+	} {
+		assert.True(t, checkPasswordHash(hash, "secret"), hash)
+		assert.False(t, checkPasswordHash(hash, "wrong"), hash)
+	}
+
+	assert.False(t, checkPasswordHash("secret", "secret"), "plain text passwords are not supported")
+	assert.False(t, checkPasswordHash("$apr1$broken", "secret"))
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	users, err := parseHtpasswd([]byte("# comment\n\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n bob:$apr1$a$b \n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"alice": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "bob": "$apr1$a$b"}, users)
+
+	for _, content := range []string{"alice", ":hash", "alice:"} {
+		_, err := parseHtpasswd([]byte(content))
+		assert.Error(t, err, content)
+	}
+}
+
+// staticAuthenticator authenticates requests by the `X-User` header.
+type staticAuthenticator struct{}
+
+func (staticAuthenticator) Authenticate(r *http.Request) (string, error) {
+	switch user := r.Header.Get("X-User"); user {
+	case "":
+		return "", ErrNoCredentials
+	case "broken":
+		return "", errors.New("backend is not available")
+	case "banned":
+		return "", ErrAccessDenied
+	default:
+		return user, nil
+	}
+}
+
+func (staticAuthenticator) Challenge() string { return "Custom" }
+
+func TestFileServer_ServeHTTP_Auth(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for _, dir := range []string{"staging", "docs", "docs/public"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, dir), 0700))
+	}
+
+	for name, content := range map[string]string{
+		"index.html":             "index",
+		"staging/index.html":     "staging",
+		"docs/index.html":        "docs",
+		"docs/public/index.html": "public docs",
+		".htpasswd":              "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	basicAuth, err := NewBasicAuth(`Staging "previews"`, filepath.Join(tmpDir, ".htpasswd"))
+	assert.NoError(t, err)
+
+	basicAuth.file.checkInterval = 0
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot: tmpDir,
+		AuthRules: []AuthRule{
+			{Path: "/staging/*", Authenticator: basicAuth},
+			{Path: "/docs/public/*"},
+			{Path: "/docs/*", Authenticator: staticAuthenticator{}, Users: []string{"alice", "broken", "banned"}},
+		},
+		RewriteRules: []RewriteRule{{From: "/preview", To: "/staging/index.html"}},
+		CacheEnabled: true,
+	})
+	assert.NoError(t, err)
+
+	serve := func(uri string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, uri, nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		if setup != nil {
+			setup(req)
+		}
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	basic := func(user, password string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, password) }
+	}
+
+	xUser := func(user string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("X-User", user) }
+	}
+
+	rawPath := func(urlPath string) func(r *http.Request) { // path is not parsed (and cleaned) by the client
+		return func(r *http.Request) { r.URL.Path = urlPath }
+	}
+
+	for i := 0; i < 2; i++ { // second iteration uses cache
+		for _, tt := range []struct {
+			uri       string
+			setup     func(r *http.Request)
+			wantCode  int
+			wantBody  string
+			challenge string
+		}{
+			{uri: "/", wantCode: http.StatusOK, wantBody: "index"},
+			{uri: "/staging/", wantCode: http.StatusUnauthorized, challenge: `Basic realm="Staging \"previews\"", charset="UTF-8"`},
+			{uri: "/preview", wantCode: http.StatusUnauthorized},
+			{uri: "/staging/", setup: basic("alice", "wrong"), wantCode: http.StatusUnauthorized},
+			{uri: "/staging/", setup: basic("bob", "secret"), wantCode: http.StatusUnauthorized},
+			{uri: "/staging/", setup: basic("alice", "secret"), wantCode: http.StatusOK, wantBody: "staging"},
+			{uri: "/preview", setup: basic("alice", "secret"), wantCode: http.StatusOK, wantBody: "staging"},
+			{uri: "/docs/public/", wantCode: http.StatusOK, wantBody: "public docs"},
+			{uri: "/docs/", wantCode: http.StatusUnauthorized, challenge: "Custom"},
+			{uri: "/docs/", setup: xUser("alice"), wantCode: http.StatusOK, wantBody: "docs"},
+			{uri: "/docs/", setup: xUser("bob"), wantCode: http.StatusForbidden},
+			{uri: "/docs/", setup: xUser("banned"), wantCode: http.StatusForbidden},
+			{uri: "/docs/", setup: xUser("broken"), wantCode: http.StatusInternalServerError},
+			{uri: "/docs/missing", setup: xUser("alice"), wantCode: http.StatusNotFound},
+			{uri: "/docs/missing", wantCode: http.StatusUnauthorized},
+			{uri: "/", setup: rawPath("/./staging/index.html"), wantCode: http.StatusUnauthorized},
+			{uri: "/", setup: rawPath("/docs/public/../../staging/"), wantCode: http.StatusUnauthorized},
+			{uri: "/", setup: rawPath("//staging/index.html"), wantCode: http.StatusUnauthorized},
+			{uri: "/", setup: rawPath("/docs/public/../index.html"), wantCode: http.StatusUnauthorized},
+			{uri: "/", setup: rawPath("/docs/./public//"), wantCode: http.StatusOK, wantBody: "public docs"},
+		} {
+			rr := serve(tt.uri, tt.setup)
+
+			assert.Equal(t, tt.wantCode, rr.Code, tt.uri)
+
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rr.Body.String(), tt.uri)
+			}
+
+			if tt.challenge != "" {
+				assert.Equal(t, tt.challenge, rr.Header().Get("WWW-Authenticate"), tt.uri)
+			}
+
+			if tt.wantCode != http.StatusUnauthorized {
+				assert.Empty(t, rr.Header().Get("WWW-Authenticate"), tt.uri)
+			}
+		}
+	}
+
+	// htpasswd file changes are applied in runtime
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, ".htpasswd"),
+		[]byte("bob:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n"), 0600))
+	assert.NoError(t, os.Chtimes(filepath.Join(tmpDir, ".htpasswd"), time.Now(), time.Now().Add(time.Minute)))
+
+	assert.Equal(t, http.StatusOK, serve("/staging/", basic("bob", "secret")).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("/staging/", basic("alice", "secret")).Code)
+}
+
+func TestNewBasicAuth_MissingFile(t *testing.T) {
+	_, err := NewBasicAuth("test", filepath.Join(os.TempDir(), "missing-htpasswd-file"))
+	assert.Error(t, err)
+}
+
+func TestFileServer_ServeHTTP_AuthenticatedUser(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	var user string
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot: tmpDir,
+		AuthRules: []AuthRule{{Path: "/*", Authenticator: staticAuthenticator{}}},
+	})
+	assert.NoError(t, err)
+
+	fs.ErrorHandlers = []ErrorHandlerFunc{func(w http.ResponseWriter, r *http.Request, _ *FileServer, _ int) bool {
+		user, _ = AuthenticatedUser(r)
+
+		return false
+	}}
+
+	req, _ := http.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set("X-User", "alice")
+	fs.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "alice", user)
+}
+
+func TestSettings_Validate_AuthRules(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{FilesRoot: tmpDir, AuthRules: []AuthRule{
+		{Path: "/*", Authenticator: staticAuthenticator{}},
+		{Path: "^/(", Authenticator: staticAuthenticator{}},
+		{Path: "/*", Users: []string{"alice"}},
+	}}.Validate()
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"AuthRules[1]", "AuthRules[2]"}, err.(ValidationErrors).Fields())
+}
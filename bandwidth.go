@@ -0,0 +1,212 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// maxThrottledChunkSize limits data chunk size, that is written at once by the throttled response writer.
+const maxThrottledChunkSize = 32 * 1024 // 32 KiB
+
+// BandwidthRule describes response body bandwidth limit for the URL paths, that match the pattern.
+type BandwidthRule struct {
+	// Path pattern (relative to the `Settings.BasePath`): regular expression (when it starts with `^`) or glob (`*`
+	// matches any characters sequence, including `/`).
+	Path string
+
+	// Maximal response body sending rate (bytes per second) for every single response.
+	BytesPerSecond int64
+}
+
+// BandwidthSettings describes response bodies bandwidth shaping options. Only file contents sending is throttled
+// (error pages are not).
+type BandwidthSettings struct {
+	// Maximal total sending rate (bytes per second) for all responses. Zero means unlimited.
+	BytesPerSecond int64
+
+	// Per-response limits (the first matched rule is used).
+	Rules []BandwidthRule
+}
+
+// clone returns deep copy of the bandwidth settings.
+func (s BandwidthSettings) clone() BandwidthSettings {
+	s.Rules = append([]BandwidthRule(nil), s.Rules...)
+
+	return s
+}
+
+// validate checks bandwidth settings.
+func (s BandwidthSettings) validate(errs *ValidationErrors) {
+	if s.BytesPerSecond < 0 {
+		errs.add("Bandwidth.BytesPerSecond", "must not be negative")
+	}
+
+	for i, rule := range s.Rules {
+		field := fmt.Sprintf("Bandwidth.Rules[%d]", i)
+
+		if _, err := compilePathPattern(rule.Path); err != nil {
+			errs.add(field, "wrong pattern: %s", err)
+		}
+
+		if rule.BytesPerSecond <= 0 {
+			errs.add(field, "bytes per second must be positive")
+		}
+	}
+}
+
+// bandwidthLimiter is a thread-safe bandwidth limiter.
+type bandwidthLimiter struct {
+	mu        sync.Mutex
+	bucket    *tokenBucket
+	chunkSize int
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	chunkSize := maxThrottledChunkSize
+	if int64(chunkSize) > bytesPerSecond {
+		chunkSize = int(bytesPerSecond)
+	}
+
+	return &bandwidthLimiter{
+		bucket:    newTokenBucket(float64(bytesPerSecond), float64(chunkSize), time.Now()),
+		chunkSize: chunkSize,
+	}
+}
+
+// wait blocks until n bytes can be sent (or context is canceled).
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	delay := l.bucket.reserve(time.Now(), float64(n))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// bandwidthShaper contains prepared bandwidth settings and global limiter state.
+type bandwidthShaper struct {
+	global *bandwidthLimiter // nil, if total rate is not limited
+	rules  []bandwidthRule
+}
+
+// bandwidthRule is a compiled BandwidthRule.
+type bandwidthRule struct {
+	path           *regexp.Regexp
+	bytesPerSecond int64
+}
+
+// newBandwidthShaper prepares (already validated) bandwidth settings. Nil is returned, when limits are not set.
+func newBandwidthShaper(s BandwidthSettings) *bandwidthShaper {
+	if s.BytesPerSecond == 0 && len(s.Rules) == 0 {
+		return nil
+	}
+
+	shaper := &bandwidthShaper{rules: make([]bandwidthRule, 0, len(s.Rules))}
+
+	if s.BytesPerSecond > 0 {
+		shaper.global = newBandwidthLimiter(s.BytesPerSecond)
+	}
+
+	for _, rule := range s.Rules {
+		if re, err := compilePathPattern(rule.Path); err == nil && rule.BytesPerSecond > 0 {
+			shaper.rules = append(shaper.rules, bandwidthRule{path: re, bytesPerSecond: rule.BytesPerSecond})
+		}
+	}
+
+	return shaper
+}
+
+// throttle returns response writer, that throttles response body writing for the URL path (when bandwidth is limited).
+func (cfg *config) throttle(w http.ResponseWriter, r *http.Request, urlPath string) http.ResponseWriter {
+	if cfg.bandwidth == nil {
+		return w
+	}
+
+	return cfg.bandwidth.throttle(w, r, urlPath)
+}
+
+// throttle returns response writer, that throttles response body writing for the URL path. Passed writer is returned
+// as is, when the path is not limited.
+func (s *bandwidthShaper) throttle(w http.ResponseWriter, r *http.Request, urlPath string) http.ResponseWriter {
+	var limiters []*bandwidthLimiter
+
+	for _, rule := range s.rules {
+		if rule.path.MatchString(urlPath) {
+			limiters = append(limiters, newBandwidthLimiter(rule.bytesPerSecond))
+
+			break
+		}
+	}
+
+	if s.global != nil {
+		limiters = append(limiters, s.global)
+	}
+
+	if len(limiters) == 0 {
+		return w
+	}
+
+	return &throttledResponseWriter{ResponseWriter: w, ctx: r.Context(), limiters: limiters}
+}
+
+// throttledResponseWriter writes response body in chunks with the rate, limited by all passed limiters. It does not
+// implement io.ReaderFrom interface, so "zero-copy" sending is not used for the throttled responses.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*bandwidthLimiter
+}
+
+// Write implements io.Writer interface.
+func (w *throttledResponseWriter) Write(b []byte) (int, error) {
+	var written int
+
+	for len(b) > 0 {
+		chunk := len(b)
+
+		for _, l := range w.limiters {
+			if l.chunkSize < chunk {
+				chunk = l.chunkSize
+			}
+		}
+
+		for _, l := range w.limiters {
+			if err := l.wait(w.ctx, chunk); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := w.ResponseWriter.Write(b[:chunk])
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+
+		b = b[chunk:]
+	}
+
+	return written, nil
+}
+
+// Flush implements http.Flusher interface (when it is supported by the underlying writer).
+func (w *throttledResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
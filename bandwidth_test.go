@@ -0,0 +1,122 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileServer_ServeHTTP_Bandwidth(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "downloads"), 0700))
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 512) // 8 KiB
+
+	for _, name := range []string{"app.js", "downloads/installer.bin"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), content, 0600))
+	}
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot: tmpDir,
+		Bandwidth: BandwidthSettings{Rules: []BandwidthRule{{Path: "/downloads/*", BytesPerSecond: 4096}}},
+	})
+	assert.NoError(t, err)
+
+	serve := func(uri string, header http.Header) (*httptest.ResponseRecorder, time.Duration) {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, uri, nil)
+			rr     = httptest.NewRecorder()
+			start  = time.Now()
+		)
+
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		fs.ServeHTTP(rr, req)
+
+		return rr, time.Since(start)
+	}
+
+	rr, elapsed := serve("/app.js", nil)
+	assert.Equal(t, content, rr.Body.Bytes())
+	assert.Less(t, int64(elapsed), int64(time.Millisecond*500), "not limited path")
+
+	rr, elapsed = serve("/downloads/installer.bin", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, content, rr.Body.Bytes())
+	assert.Greater(t, int64(elapsed), int64(time.Millisecond*900), "4 KiB burst + 4 KiB per second")
+
+	rr, elapsed = serve("/./downloads/installer.bin", nil)
+	assert.Equal(t, content, rr.Body.Bytes())
+	assert.Greater(t, int64(elapsed), int64(time.Millisecond*900), "dot-segment path is limited too")
+
+	// range semantics are preserved
+	rr, _ = serve("/downloads/installer.bin", http.Header{"Range": {"bytes=16-31"}})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "bytes 16-31/8192", rr.Header().Get("Content-Range"))
+	assert.Equal(t, "0123456789abcdef", rr.Body.String())
+
+	rr, _ = serve("/downloads/installer.bin", http.Header{"Range": {"bytes=9000-"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rr.Code)
+}
+
+func TestThrottledResponseWriter_CanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var (
+		rr = httptest.NewRecorder()
+		w  = &throttledResponseWriter{
+			ResponseWriter: rr,
+			ctx:            ctx,
+			limiters:       []*bandwidthLimiter{newBandwidthLimiter(10)},
+		}
+	)
+
+	n, err := w.Write(make([]byte, 100))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 10, n, "burst is written")
+}
+
+func TestBandwidthShaper_Throttle(t *testing.T) {
+	shaper := newBandwidthShaper(BandwidthSettings{
+		BytesPerSecond: 1024 * 1024,
+		Rules:          []BandwidthRule{{Path: "/downloads/*", BytesPerSecond: 1024}},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	w := shaper.throttle(httptest.NewRecorder(), req, "/downloads/file")
+	assert.Len(t, w.(*throttledResponseWriter).limiters, 2)
+	assert.Equal(t, 1024, w.(*throttledResponseWriter).limiters[0].chunkSize)
+
+	w = shaper.throttle(httptest.NewRecorder(), req, "/index.html")
+	assert.Equal(t, []*bandwidthLimiter{shaper.global}, w.(*throttledResponseWriter).limiters, "global limiter is shared")
+
+	assert.Nil(t, newBandwidthShaper(BandwidthSettings{}))
+}
+
+func TestSettings_Validate_Bandwidth(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{FilesRoot: tmpDir, Bandwidth: BandwidthSettings{
+		BytesPerSecond: -1,
+		Rules:          []BandwidthRule{{Path: "/*", BytesPerSecond: 1}, {Path: "^/(", BytesPerSecond: 1}, {Path: "/*"}},
+	}}.Validate()
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Bandwidth.BytesPerSecond", "Bandwidth.Rules[1]", "Bandwidth.Rules[2]"},
+		err.(ValidationErrors).Fields())
+}
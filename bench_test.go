@@ -0,0 +1,127 @@
+package fileserver
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// benchFileSizes are the file sizes for the content serving benchmarks.
+var benchFileSizes = []int64{64 << 10, 1 << 20, 8 << 20} //nolint:gochecknoglobals
+
+// benchServingModes are the file server settings for the content serving benchmarks.
+var benchServingModes = []struct { //nolint:gochecknoglobals
+	name     string
+	settings func(size int64) Settings
+}{
+	{"read_all", func(size int64) Settings { // content is read into memory (current default approach)
+		return Settings{CacheEnabled: true, CacheMaxFileSize: size}
+	}},
+	{"mmap", func(size int64) Settings {
+		return Settings{CacheEnabled: true, CacheMaxFileSize: 1, CacheMmapMaxFileSize: size}
+	}},
+	{"sendfile", func(size int64) Settings { // file is streamed from disk
+		return Settings{}
+	}},
+}
+
+// benchFile creates the file of passed size.
+func benchFile(b *testing.B, dir string, size int64) string {
+	b.Helper()
+
+	name := strconv.FormatInt(size, 10) + ".bin"
+
+	if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0600); err != nil {
+		b.Fatal(err)
+	}
+
+	return name
+}
+
+// BenchmarkFileServer_ServeHTTP measures content serving over the real network connection (so `sendfile` can be used).
+func BenchmarkFileServer_ServeHTTP(b *testing.B) {
+	tmpDir, _ := ioutil.TempDir("", "bench-")
+	defer os.RemoveAll(tmpDir)
+
+	for _, size := range benchFileSizes {
+		name := benchFile(b, tmpDir, size)
+
+		for _, mode := range benchServingModes {
+			settings := mode.settings(size)
+			settings.FilesRoot = tmpDir
+
+			fs, err := NewFileServer(settings)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			srv := httptest.NewServer(fs)
+
+			b.Run(mode.name+"/"+name, func(b *testing.B) {
+				b.SetBytes(size)
+				b.ReportAllocs()
+
+				for i := 0; i < b.N; i++ {
+					resp, err := srv.Client().Get(srv.URL + "/" + name)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					_, _ = io.Copy(ioutil.Discard, resp.Body)
+					_ = resp.Body.Close()
+
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("unexpected status code %d", resp.StatusCode)
+					}
+				}
+			})
+
+			srv.Close()
+		}
+	}
+}
+
+// BenchmarkCacheableContent measures cache miss costs (file content loading).
+func BenchmarkCacheableContent(b *testing.B) {
+	tmpDir, _ := ioutil.TempDir("", "bench-")
+	defer os.RemoveAll(tmpDir)
+
+	for _, size := range benchFileSizes {
+		name := benchFile(b, tmpDir, size)
+
+		for _, mode := range benchServingModes[:2] {
+			settings := mode.settings(size)
+			settings.FilesRoot = tmpDir
+
+			s, err := prepareSettings(settings)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			cfg := newConfig(s)
+
+			b.Run(mode.name+"/"+name, func(b *testing.B) {
+				b.SetBytes(size)
+				b.ReportAllocs()
+
+				for i := 0; i < b.N; i++ {
+					resolved, err := (&FileServer{}).resolveFile(cfg, name)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					if cacheableContent(cfg, resolved) == nil {
+						b.Fatal("content is not loaded")
+					}
+
+					_ = resolved.Close()
+				}
+			})
+		}
+	}
+}
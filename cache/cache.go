@@ -21,10 +21,25 @@ type (
 		Count() uint32
 	}
 
+	// Deleter is an optional Cacher extension for the cached items invalidation (is used, when files are changed using
+	// the file server write operations).
+	Deleter interface {
+		// Delete an item from the cache. Does nothing, if the key is not in the cache.
+		Delete(key string)
+	}
+
 	// Item is structured cache item.
 	Item struct {
 		ModifiedTime time.Time
 		Content      io.ReadSeeker
+
+		// Source file size and inode number (zero, if unknown) are used for the cached content revalidation.
+		Size  int64
+		Inode uint64
+
+		// Item freshness deadline. Item, that is not fresh, is served while it is refreshed in background (zero
+		// value means, that item is fresh until it is expired).
+		FreshUntil time.Time
 	}
 )
 
@@ -56,6 +71,11 @@ func (c *InMemoryCache) Set(key string, ttl time.Duration, item *Item) {
 	c.engine.Set(key, item, ttl)
 }
 
+// Delete an item from the cache. Does nothing, if the key is not in the cache.
+func (c *InMemoryCache) Delete(key string) {
+	c.engine.Delete(key)
+}
+
 // Count returns the number of items in the cache. This may include items that have expired, but have not yet been
 // cleaned up.
 func (c *InMemoryCache) Count() uint32 {
@@ -45,3 +45,19 @@ func TestInMemoryCache_CRD(t *testing.T) {
 
 	assert.Equal(t, uint32(0), cache.Count())
 }
+
+func TestInMemoryCache_Delete(t *testing.T) {
+	var cache Cacher = NewInMemoryCache(time.Minute)
+
+	cache.Set("foo", time.Minute, &Item{Content: bytes.NewReader([]byte("abc"))})
+
+	deleter, ok := cache.(Deleter)
+	assert.True(t, ok)
+
+	deleter.Delete("foo")
+	deleter.Delete("bar")
+
+	_, exists := cache.Get("foo")
+	assert.False(t, exists)
+	assert.Equal(t, uint32(0), cache.Count())
+}
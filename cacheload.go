@@ -0,0 +1,193 @@
+package fileserver
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/avto-dev/go-simple-fileserver/cache"
+)
+
+// loadGroup deduplicates concurrent cache items loading (per cache key). Zero value is ready to use.
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+// loadCall is an in-progress or completed cache item loading.
+type loadCall struct {
+	done chan struct{}
+	item *cache.Item // nil, if item was not loaded
+}
+
+// start registers the call for the key. Call in progress is returned (with false), when it exists.
+func (g *loadGroup) start(key string) (*loadCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if call, found := g.calls[key]; found {
+		return call, false
+	}
+
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+
+	call := &loadCall{done: make(chan struct{})}
+	g.calls[key] = call
+
+	return call, true
+}
+
+// run calls the load function and wakes up waiting goroutines.
+func (g *loadGroup) run(key string, call *loadCall, load func() *cache.Item) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		close(call.done)
+	}()
+
+	call.item = load()
+}
+
+// do calls the load function for the key, or waits for the result of the call in progress (then the load function is
+// not called).
+func (g *loadGroup) do(key string, load func() *cache.Item) *cache.Item {
+	call, started := g.start(key)
+
+	if started {
+		g.run(key, call, load)
+	} else {
+		<-call.done
+	}
+
+	return call.item
+}
+
+// doAsync calls the load function for the key in background, if there is no call in progress.
+func (g *loadGroup) doAsync(key string, load func() *cache.Item) {
+	if call, started := g.start(key); started {
+		go g.run(key, call, load)
+	}
+}
+
+// cacheLimitsAllow checks, that the file of passed size can be placed into the cache.
+func (cfg *config) cacheLimitsAllow(size int64) bool {
+	return size <= cfg.settings.CacheMaxFileSize || size <= cfg.settings.CacheMmapMaxFileSize
+}
+
+// loadCacheItem reads the resolved file content into the cache. Only one of the concurrent requests for the same file
+// reads it, others wait for the result. Nil is returned, when the file can not be cached.
+func (fs *FileServer) loadCacheItem(cfg *config, f *resolvedFile) *cache.Item {
+	if !cfg.cacheLimitsAllow(f.info.Size()) {
+		return nil
+	}
+
+	key := cfg.cacheKey(f.layer.key(f.name))
+
+	return fs.cacheLoads.do(key, func() *cache.Item {
+		return fs.storeCacheItem(cfg, key, f)
+	})
+}
+
+// refreshCacheItem reloads the (stale) cached file content in background. Item is removed from the cache, when the
+// file can not be cached anymore (eg.: it was removed).
+func (fs *FileServer) refreshCacheItem(cfg *config, l *layer, name string) {
+	key := cfg.cacheKey(l.key(name))
+
+	fs.cacheLoads.doAsync(key, func() *cache.Item {
+		var item *cache.Item
+
+		if file, err := l.fs.Open(name); err == nil {
+			if info, err := file.Stat(); err == nil && info.Mode().IsRegular() && cfg.cacheLimitsAllow(info.Size()) {
+				item = fs.storeCacheItem(cfg, key, &resolvedFile{layer: l, name: name, file: file, info: info})
+			}
+
+			_ = file.Close()
+		}
+
+		if deleter, ok := fs.Cache.(cache.Deleter); ok && item == nil {
+			deleter.Delete(key)
+		}
+
+		return item
+	})
+}
+
+// storeCacheItem reads the file content and puts it into the cache. Nil is returned, when the content can not be read.
+func (fs *FileServer) storeCacheItem(cfg *config, key string, f *resolvedFile) *cache.Item {
+	content := cacheableContent(cfg, f)
+	if content == nil {
+		return nil
+	}
+
+	item := &cache.Item{
+		ModifiedTime: f.info.ModTime(),
+		Content:      content,
+		Size:         f.info.Size(),
+		Inode:        fileInode(f.info),
+	}
+
+	switch now := time.Now(); {
+	case cfg.settings.CacheRevalidateInterval > 0:
+		item.FreshUntil = now.Add(cfg.settings.CacheRevalidateInterval)
+	case cfg.settings.CacheStaleTTL > 0:
+		item.FreshUntil = now.Add(cfg.settings.CacheTTL)
+	}
+
+	fs.Cache.Set(key, cfg.cacheItemTTL(), item)
+
+	return item
+}
+
+// cacheItemTTL returns cached items lifetime (including the stale content serving time).
+func (cfg *config) cacheItemTTL() time.Duration {
+	return cfg.settings.CacheTTL + cfg.settings.CacheStaleTTL
+}
+
+// revalidateCacheItem checks, that the cached file content can be served. Item, that is not fresh anymore (see
+// `cache.Item.FreshUntil`), is revalidated using the file stat (when revalidation is enabled) and refreshed in
+// background (when stale content serving is enabled). False is returned, when cached content is outdated (it is
+// removed from the cache, when it is possible).
+func (fs *FileServer) revalidateCacheItem(cfg *config, l *layer, name string, item *cache.Item) bool {
+	now := time.Now()
+
+	if item.FreshUntil.IsZero() || !now.After(item.FreshUntil) {
+		return true
+	}
+
+	key := cfg.cacheKey(l.key(name))
+
+	if cfg.settings.CacheRevalidateInterval > 0 {
+		if info, err := l.stat(name); err == nil && isSameFile(item, info) {
+			fresh := *item // cached item is shared between requests, so it is not modified
+			fresh.FreshUntil = now.Add(cfg.settings.CacheRevalidateInterval)
+
+			fs.Cache.Set(key, cfg.cacheItemTTL(), &fresh)
+
+			return true
+		}
+	}
+
+	if cfg.settings.CacheStaleTTL > 0 {
+		fs.refreshCacheItem(cfg, l, name)
+
+		return true
+	}
+
+	if deleter, ok := fs.Cache.(cache.Deleter); ok {
+		deleter.Delete(key)
+	}
+
+	return false
+}
+
+// isSameFile checks, that the cached content was read from the file (inode is compared, when it is known).
+func isSameFile(item *cache.Item, info os.FileInfo) bool {
+	return info.Mode().IsRegular() &&
+		info.Size() == item.Size &&
+		info.ModTime().Equal(item.ModifiedTime) &&
+		(item.Inode == 0 || fileInode(info) == item.Inode)
+}
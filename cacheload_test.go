@@ -0,0 +1,224 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/avto-dev/go-simple-fileserver/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadGroup(t *testing.T) {
+	var (
+		g       loadGroup
+		calls   int32
+		release = make(chan struct{})
+		want    = &cache.Item{}
+		wg      sync.WaitGroup
+	)
+
+	load := func() *cache.Item {
+		atomic.AddInt32(&calls, 1)
+		<-release
+
+		return want
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			assert.Equal(t, want, g.do("key", load))
+		}()
+	}
+
+	time.Sleep(time.Millisecond * 20) // wait for the goroutines start
+
+	g.doAsync("key", load) // call is in progress, so it is ignored
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Len(t, g.calls, 0)
+	assert.Nil(t, g.do("other", func() *cache.Item { return nil }))
+}
+
+// slowFileSystem counts and slows down the files reading.
+type slowFileSystem struct {
+	http.FileSystem
+	reads int32
+}
+
+func (fs *slowFileSystem) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &slowFile{File: f, fs: fs}, nil
+}
+
+type slowFile struct {
+	http.File
+	fs      *slowFileSystem
+	started bool
+}
+
+func (f *slowFile) Read(b []byte) (int, error) {
+	if !f.started {
+		f.started = true
+
+		atomic.AddInt32(&f.fs.reads, 1)
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	return f.File.Read(b)
+}
+
+func TestFileServer_ServeHTTP_CacheLoadsCoalescing(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "popular.txt"), []byte("popular"), 0600))
+
+	layer := &slowFileSystem{FileSystem: http.Dir(tmpDir)}
+
+	fs, err := NewFileServer(Settings{FilesRoot: tmpDir, Layers: []http.FileSystem{layer}, CacheEnabled: true})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			rr := httptest.NewRecorder()
+			fs.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/popular.txt", nil))
+
+			assert.Equal(t, "popular", rr.Body.String())
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&layer.reads), "file is read once")
+}
+
+func TestFileServer_ServeHTTP_StaleWhileRevalidate(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	filePath := filepath.Join(tmpDir, "file.txt")
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte("v1"), 0600))
+
+	const cacheTTL = time.Millisecond * 100
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:     tmpDir,
+		CacheEnabled:  true,
+		CacheTTL:      cacheTTL,
+		CacheStaleTTL: time.Minute,
+	})
+	assert.NoError(t, err)
+
+	serve := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		fs.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+
+		return rr
+	}
+
+	assert.Equal(t, "v1", serve().Body.String())
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte("v2"), 0600))
+	assert.Equal(t, "v1", serve().Body.String(), "fresh cached content")
+
+	time.Sleep(cacheTTL)
+
+	assert.Equal(t, "v1", serve().Body.String(), "stale content is served while it is refreshed")
+	assert.Eventually(t, func() bool { return serve().Body.String() == "v2" }, time.Second, time.Millisecond)
+
+	assert.NoError(t, os.Remove(filePath))
+	time.Sleep(cacheTTL)
+
+	assert.Equal(t, http.StatusOK, serve().Code, "stale content of the removed file")
+	assert.Eventually(t, func() bool { return serve().Code == http.StatusNotFound }, time.Second, time.Millisecond)
+}
+
+func TestFileServer_ServeHTTP_CacheRevalidation(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	filePath := filepath.Join(tmpDir, "file.txt")
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte("v1"), 0600))
+
+	const interval = time.Millisecond * 50
+
+	layer := &slowFileSystem{FileSystem: http.Dir(tmpDir)}
+
+	settings := Settings{
+		FilesRoot:               tmpDir,
+		Layers:                  []http.FileSystem{layer},
+		CacheEnabled:            true,
+		CacheTTL:                time.Minute,
+		CacheRevalidateInterval: interval,
+	}
+
+	fs, err := NewFileServer(settings)
+	assert.NoError(t, err)
+
+	serve := func() string {
+		rr := httptest.NewRecorder()
+		fs.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+
+		return rr.Body.String()
+	}
+
+	assert.Equal(t, "v1", serve())
+
+	item, found := fs.Cache.Get(fs.config().layers[0].key("/file.txt"))
+	if assert.True(t, found) {
+		assert.Equal(t, int64(2), item.Size)
+		assert.NotZero(t, item.Inode)
+	}
+
+	time.Sleep(interval)
+
+	assert.Equal(t, "v1", serve())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&layer.reads), "unchanged file is not read again")
+
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte("v2 (changed size)"), 0600))
+	assert.Equal(t, "v1", serve(), "cached content is fresh")
+
+	time.Sleep(interval)
+	assert.Equal(t, "v2 (changed size)", serve())
+
+	// file is replaced with the same size and modification time (only inode is changed)
+	info, _ := os.Stat(filePath)
+	assert.NoError(t, ioutil.WriteFile(filePath+".new", []byte("v3 (same size)   "), 0600))
+	assert.NoError(t, os.Chtimes(filePath+".new", info.ModTime(), info.ModTime()))
+	assert.NoError(t, os.Rename(filePath+".new", filePath))
+
+	time.Sleep(interval)
+	assert.Equal(t, "v3 (same size)   ", serve())
+
+	// stale content is served while the changed file is reloaded
+	settings.CacheStaleTTL = time.Minute
+	assert.NoError(t, fs.UpdateSettings(settings))
+	assert.NoError(t, os.Remove(filePath))
+
+	time.Sleep(interval)
+	assert.Equal(t, "v3 (same size)   ", serve())
+	assert.Eventually(t, func() bool { return serve() != "v3 (same size)   " }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	fileserver "github.com/avto-dev/go-simple-fileserver"
+)
+
+const (
+	defaultListen          = ":8080"
+	defaultShutdownTimeout = time.Second * 15
+	envPrefix              = "FILESERVER_"
+	defaultAuthRealm       = "Restricted"
+	hiddenSecret           = "********"
+)
+
+// duration is a time.Duration, that can be unmarshalled from the strings like `5s` or `1m30s`.
+type duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler interface.
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = duration(v)
+
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler interface.
+func (d duration) MarshalText() ([]byte, error) { return []byte(time.Duration(d).String()), nil }
+
+type (
+	// config describes application configuration (can be loaded from the YAML or TOML file, environment variables and
+	// command line flags).
+	config struct {
+		Listen          string    `yaml:"listen" toml:"listen"`
+		ShutdownTimeout duration  `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+		TLS             tlsConfig `yaml:"tls" toml:"tls"`
+
+		FilesRoot               string            `yaml:"files_root" toml:"files_root"`
+		Overlays                []string          `yaml:"overlays" toml:"overlays"`
+		BasePath                string            `yaml:"base_path" toml:"base_path"`
+		IndexFileName           string            `yaml:"index_file" toml:"index_file"`
+		ErrorFileName           string            `yaml:"error_file" toml:"error_file"`
+		ErrorFileNames          map[string]string `yaml:"error_files" toml:"error_files"`
+		Languages               []string          `yaml:"languages" toml:"languages"`
+		Rewrites                []rewriteConfig   `yaml:"rewrites" toml:"rewrites"`
+		RedirectsFileName       string            `yaml:"redirects_file" toml:"redirects_file"`
+		Headers                 map[string]string `yaml:"headers" toml:"headers"`
+		SecureHeaders           bool              `yaml:"secure_headers" toml:"secure_headers"`
+		HeadersFileName         string            `yaml:"headers_file" toml:"headers_file"`
+		CORS                    corsConfig        `yaml:"cors" toml:"cors"`
+		Auth                    []authConfig      `yaml:"auth" toml:"auth"`
+		SignedURLs              signedURLsConfig  `yaml:"signed_urls" toml:"signed_urls"`
+		RateLimit               rateLimitConfig   `yaml:"rate_limit" toml:"rate_limit"`
+		Bandwidth               bandwidthConfig   `yaml:"bandwidth" toml:"bandwidth"`
+		Ranges                  rangesConfig      `yaml:"ranges" toml:"ranges"`
+		Write                   writeConfig       `yaml:"write" toml:"write"`
+		WebDAV                  webDAVConfig      `yaml:"webdav" toml:"webdav"`
+		Tus                     tusConfig         `yaml:"tus" toml:"tus"`
+		RedirectIndexFileToRoot bool              `yaml:"redirect_index_to_root" toml:"redirect_index_to_root"`
+		AllowedHTTPMethods      []string          `yaml:"allowed_methods" toml:"allowed_methods"`
+		Cache                   cacheConfig       `yaml:"cache" toml:"cache"`
+		StrictValidation        bool              `yaml:"strict_validation" toml:"strict_validation"`
+	}
+
+	tlsConfig struct {
+		CertFile string `yaml:"cert_file" toml:"cert_file"`
+		KeyFile  string `yaml:"key_file" toml:"key_file"`
+	}
+
+	rewriteConfig struct {
+		From   string `yaml:"from" toml:"from"`
+		To     string `yaml:"to" toml:"to"`
+		Status int    `yaml:"status,omitempty" toml:"status,omitempty"`
+	}
+
+	corsConfig struct {
+		AllowedOrigins   []string `yaml:"allowed_origins" toml:"allowed_origins"`
+		AllowedMethods   []string `yaml:"allowed_methods" toml:"allowed_methods"`
+		AllowedHeaders   []string `yaml:"allowed_headers" toml:"allowed_headers"`
+		ExposedHeaders   []string `yaml:"exposed_headers" toml:"exposed_headers"`
+		AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials"`
+		MaxAge           duration `yaml:"max_age" toml:"max_age"`
+	}
+
+	authConfig struct {
+		Path         string   `yaml:"path" toml:"path"`
+		HtpasswdFile string   `yaml:"htpasswd_file,omitempty" toml:"htpasswd_file,omitempty"` // public, when empty
+		Realm        string   `yaml:"realm,omitempty" toml:"realm,omitempty"`
+		Users        []string `yaml:"users,omitempty" toml:"users,omitempty"`
+	}
+
+	signedURLsConfig struct {
+		Paths        []string           `yaml:"paths" toml:"paths"`
+		Keys         []signingKeyConfig `yaml:"keys" toml:"keys"`
+		BindClientIP bool               `yaml:"bind_client_ip" toml:"bind_client_ip"`
+	}
+
+	signingKeyConfig struct {
+		ID     string `yaml:"id" toml:"id"`
+		Secret string `yaml:"secret" toml:"secret"`
+	}
+
+	rateLimitConfig struct {
+		RequestsPerSecond        float64 `yaml:"requests_per_second" toml:"requests_per_second"`
+		Burst                    int     `yaml:"burst" toml:"burst"`
+		ClientKeyHeader          string  `yaml:"client_key_header" toml:"client_key_header"`
+		MaxClients               int     `yaml:"max_clients" toml:"max_clients"`
+		MaxInFlight              int     `yaml:"max_in_flight" toml:"max_in_flight"`
+		LargeFileSize            int64   `yaml:"large_file_size" toml:"large_file_size"`
+		MaxLargeStreams          int     `yaml:"max_large_streams" toml:"max_large_streams"`
+		MaxLargeStreamsPerClient int     `yaml:"max_large_streams_per_client" toml:"max_large_streams_per_client"`
+	}
+
+	bandwidthConfig struct {
+		BytesPerSecond int64                 `yaml:"bytes_per_second" toml:"bytes_per_second"`
+		Rules          []bandwidthRuleConfig `yaml:"rules" toml:"rules"`
+	}
+
+	bandwidthRuleConfig struct {
+		Path           string `yaml:"path" toml:"path"`
+		BytesPerSecond int64  `yaml:"bytes_per_second" toml:"bytes_per_second"`
+	}
+
+	rangesConfig struct {
+		DisabledPaths []string `yaml:"disabled_paths" toml:"disabled_paths"`
+		MaxRanges     int      `yaml:"max_ranges" toml:"max_ranges"`
+		Coalesce      bool     `yaml:"coalesce" toml:"coalesce"`
+	}
+
+	writeConfig struct {
+		Enabled      bool     `yaml:"enabled" toml:"enabled"`
+		Paths        []string `yaml:"paths" toml:"paths"`
+		Deny         []string `yaml:"deny" toml:"deny"`
+		Delete       bool     `yaml:"delete" toml:"delete"`
+		MaxFileSize  int64    `yaml:"max_file_size" toml:"max_file_size"`
+		Overwrite    bool     `yaml:"overwrite" toml:"overwrite"`
+		HtpasswdFile string   `yaml:"htpasswd_file" toml:"htpasswd_file"`
+		Realm        string   `yaml:"realm,omitempty" toml:"realm,omitempty"`
+		Users        []string `yaml:"users,omitempty" toml:"users,omitempty"`
+	}
+
+	webDAVConfig struct {
+		Enabled  bool `yaml:"enabled" toml:"enabled"`
+		ReadOnly bool `yaml:"read_only" toml:"read_only"`
+	}
+
+	tusConfig struct {
+		BasePath   string   `yaml:"base_path" toml:"base_path"` // disabled, when empty
+		StagingDir string   `yaml:"staging_dir" toml:"staging_dir"`
+		Expiration duration `yaml:"expiration" toml:"expiration"`
+	}
+
+	cacheConfig struct {
+		Enabled            bool     `yaml:"enabled" toml:"enabled"`
+		TTL                duration `yaml:"ttl" toml:"ttl"`
+		StaleTTL           duration `yaml:"stale_ttl" toml:"stale_ttl"`
+		RevalidateInterval duration `yaml:"revalidate_interval" toml:"revalidate_interval"`
+		MaxFileSize        int64    `yaml:"max_file_size" toml:"max_file_size"`
+		MmapMaxFileSize    int64    `yaml:"mmap_max_file_size" toml:"mmap_max_file_size"`
+		MaxItems           uint32   `yaml:"max_items" toml:"max_items"`
+	}
+)
+
+// option describes single configuration option, that can be set using environment variable and command line flag.
+type option struct {
+	flag, env, usage string
+	boolean          bool
+	apply            func(cfg *config, value string) error
+}
+
+func stringOption(target func(*config) *string) func(*config, string) error {
+	return func(cfg *config, value string) error {
+		*target(cfg) = value
+
+		return nil
+	}
+}
+
+func listOption(target func(*config) *[]string) func(*config, string) error {
+	return func(cfg *config, value string) error {
+		list := make([]string, 0)
+
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+
+		*target(cfg) = list
+
+		return nil
+	}
+}
+
+func boolOption(target func(*config) *bool) func(*config, string) error {
+	return func(cfg *config, value string) (err error) {
+		*target(cfg), err = strconv.ParseBool(value)
+
+		return
+	}
+}
+
+func durationOption(target func(*config) *duration) func(*config, string) error {
+	return func(cfg *config, value string) error {
+		return target(cfg).UnmarshalText([]byte(value))
+	}
+}
+
+func uintOption(bitSize int, set func(*config, uint64)) func(*config, string) error {
+	return func(cfg *config, value string) error {
+		n, err := strconv.ParseUint(value, 10, bitSize)
+		if err != nil {
+			return err
+		}
+
+		set(cfg, n)
+
+		return nil
+	}
+}
+
+// options returns all supported configuration options.
+func options() []option { //nolint:funlen
+	return []option{
+		{
+			flag: "listen", env: "LISTEN", usage: "address to listen on",
+			apply: stringOption(func(c *config) *string { return &c.Listen }),
+		},
+		{
+			flag: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", usage: "graceful shutdown timeout",
+			apply: durationOption(func(c *config) *duration { return &c.ShutdownTimeout }),
+		},
+		{
+			flag: "tls-cert", env: "TLS_CERT", usage: "TLS certificate file path",
+			apply: stringOption(func(c *config) *string { return &c.TLS.CertFile }),
+		},
+		{
+			flag: "tls-key", env: "TLS_KEY", usage: "TLS private key file path",
+			apply: stringOption(func(c *config) *string { return &c.TLS.KeyFile }),
+		},
+		{
+			flag: "root", env: "FILES_ROOT", usage: "directory with files for serving",
+			apply: stringOption(func(c *config) *string { return &c.FilesRoot }),
+		},
+		{
+			flag: "overlays", env: "OVERLAYS", usage: "directories, layered over the files root (comma-separated)",
+			apply: listOption(func(c *config) *[]string { return &c.Overlays }),
+		},
+		{
+			flag: "base-path", env: "BASE_PATH", usage: "URL path prefix, that the server is mounted under",
+			apply: stringOption(func(c *config) *string { return &c.BasePath }),
+		},
+		{
+			flag: "index", env: "INDEX_FILE", usage: "index file name",
+			apply: stringOption(func(c *config) *string { return &c.IndexFileName }),
+		},
+		{
+			flag: "error-file", env: "ERROR_FILE", usage: "error page template file name",
+			apply: stringOption(func(c *config) *string { return &c.ErrorFileName }),
+		},
+		{
+			flag: "languages", env: "LANGUAGES", usage: "supported languages (comma-separated)",
+			apply: listOption(func(c *config) *[]string { return &c.Languages }),
+		},
+		{
+			flag: "redirects-file", env: "REDIRECTS_FILE", usage: "redirects file name (like _redirects)",
+			apply: stringOption(func(c *config) *string { return &c.RedirectsFileName }),
+		},
+		{
+			flag: "secure-headers", env: "SECURE_HEADERS", boolean: true,
+			usage: "add security related response headers (HSTS, CSP and so on)",
+			apply: boolOption(func(c *config) *bool { return &c.SecureHeaders }),
+		},
+		{
+			flag: "headers-file", env: "HEADERS_FILE", usage: "response headers file name (like _headers)",
+			apply: stringOption(func(c *config) *string { return &c.HeadersFileName }),
+		},
+		{
+			flag: "cors-origins", env: "CORS_ORIGINS", usage: "CORS allowed origins (comma-separated)",
+			apply: listOption(func(c *config) *[]string { return &c.CORS.AllowedOrigins }),
+		},
+		{
+			flag: "webdav", env: "WEBDAV", boolean: true, usage: "enable WebDAV (read-write in write mode)",
+			apply: boolOption(func(c *config) *bool { return &c.WebDAV.Enabled }),
+		},
+		{
+			flag: "redirect-index", env: "REDIRECT_INDEX_TO_ROOT", boolean: true,
+			usage: "redirect index file requests to the directory root",
+			apply: boolOption(func(c *config) *bool { return &c.RedirectIndexFileToRoot }),
+		},
+		{
+			flag: "methods", env: "ALLOWED_METHODS", usage: "allowed HTTP methods (comma-separated)",
+			apply: listOption(func(c *config) *[]string { return &c.AllowedHTTPMethods }),
+		},
+		{
+			flag: "cache", env: "CACHE_ENABLED", boolean: true, usage: "enable files caching",
+			apply: boolOption(func(c *config) *bool { return &c.Cache.Enabled }),
+		},
+		{
+			flag: "cache-ttl", env: "CACHE_TTL", usage: "cached files lifetime",
+			apply: durationOption(func(c *config) *duration { return &c.Cache.TTL }),
+		},
+		{
+			flag: "cache-stale-ttl", env: "CACHE_STALE_TTL", usage: "stale cached files lifetime (stale-while-revalidate)",
+			apply: durationOption(func(c *config) *duration { return &c.Cache.StaleTTL }),
+		},
+		{
+			flag: "cache-revalidate", env: "CACHE_REVALIDATE_INTERVAL",
+			usage: "cached files revalidation interval (file size, modification time and inode are checked)",
+			apply: durationOption(func(c *config) *duration { return &c.Cache.RevalidateInterval }),
+		},
+		{
+			flag: "cache-max-file-size", env: "CACHE_MAX_FILE_SIZE", usage: "maximal cached file size (in bytes)",
+			apply: uintOption(63, func(c *config, n uint64) { c.Cache.MaxFileSize = int64(n) }), //nolint:gomnd
+		},
+		{
+			flag: "cache-mmap-max-file-size", env: "CACHE_MMAP_MAX_FILE_SIZE",
+			usage: "maximal memory-mapped cached file size (in bytes)",
+			apply: uintOption(63, func(c *config, n uint64) { c.Cache.MmapMaxFileSize = int64(n) }), //nolint:gomnd
+		},
+		{
+			flag: "cache-max-items", env: "CACHE_MAX_ITEMS", usage: "maximal cached files count",
+			apply: uintOption(32, func(c *config, n uint64) { c.Cache.MaxItems = uint32(n) }), //nolint:gomnd
+		},
+		{
+			flag: "strict", env: "STRICT_VALIDATION", boolean: true, usage: "error page template files must exist",
+			apply: boolOption(func(c *config) *bool { return &c.StrictValidation }),
+		},
+	}
+}
+
+// stringFlag is a flag.Value, that remembers raw string value.
+type stringFlag struct{ value string }
+
+func (f *stringFlag) String() string { return f.value }
+
+func (f *stringFlag) Set(v string) error {
+	f.value = v
+
+	return nil
+}
+
+// boolFlag is a stringFlag, that can be used without value (`--cache`).
+type boolFlag struct{ stringFlag }
+
+func (f *boolFlag) IsBoolFlag() bool { return true }
+
+// flags describes parsed command line flags.
+type flags struct {
+	configFile string
+	check      bool
+	values     map[string]flag.Value // only explicitly set flags
+}
+
+// parseFlags parses command line arguments.
+func parseFlags(name string, args []string) (*flags, error) {
+	var (
+		set    = flag.NewFlagSet(name, flag.ContinueOnError)
+		result = &flags{values: make(map[string]flag.Value)}
+	)
+
+	set.StringVar(&result.configFile, "config", "", "configuration file path (YAML or TOML)")
+	set.BoolVar(&result.check, "check", false, "validate configuration, print effective settings and exit")
+
+	for _, opt := range options() {
+		usage := fmt.Sprintf("%s (env: %s%s)", opt.usage, envPrefix, opt.env)
+
+		if opt.boolean {
+			set.Var(&boolFlag{}, opt.flag, usage)
+		} else {
+			set.Var(&stringFlag{}, opt.flag, usage)
+		}
+	}
+
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+
+	set.Visit(func(f *flag.Flag) { result.values[f.Name] = f.Value })
+
+	return result, nil
+}
+
+// loadConfig builds configuration using defaults, configuration file, environment variables and command line flags
+// (in that order, latter sources override former).
+func loadConfig(f *flags, getenv func(string) string) (*config, error) {
+	cfg := &config{
+		Listen:          defaultListen,
+		ShutdownTimeout: duration(defaultShutdownTimeout),
+	}
+
+	configFile := f.configFile
+	if configFile == "" {
+		configFile = getenv(envPrefix + "CONFIG")
+	}
+
+	if configFile != "" {
+		if err := cfg.loadFile(configFile); err != nil {
+			return nil, fmt.Errorf("configuration file %s loading failed: %w", configFile, err)
+		}
+	}
+
+	for _, opt := range options() {
+		if value, ok := lookupEnv(getenv, envPrefix+opt.env); ok {
+			if err := opt.apply(cfg, value); err != nil {
+				return nil, fmt.Errorf("wrong environment variable %s%s value: %w", envPrefix, opt.env, err)
+			}
+		}
+	}
+
+	for _, opt := range options() {
+		if value, ok := f.values[opt.flag]; ok {
+			if err := opt.apply(cfg, value.String()); err != nil {
+				return nil, fmt.Errorf("wrong flag --%s value: %w", opt.flag, err)
+			}
+		}
+	}
+
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return nil, errors.New("both TLS certificate and key files must be set")
+	}
+
+	return cfg, nil
+}
+
+func lookupEnv(getenv func(string) string, name string) (string, bool) {
+	value := getenv(name)
+
+	return value, value != ""
+}
+
+// loadFile loads configuration from the YAML or TOML file (format is detected by file extension).
+func (cfg *config) loadFile(name string) error {
+	data, err := ioutil.ReadFile(name) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yml", ".yaml":
+		return yaml.Unmarshal(data, cfg)
+
+	case ".toml":
+		return toml.Unmarshal(data, cfg)
+	}
+
+	return errors.New("unsupported file format (allowed: yaml, yml, toml)")
+}
+
+// Settings converts configuration into file server settings.
+func (cfg *config) Settings() (fileserver.Settings, error) { //nolint:funlen
+	layers := make([]http.FileSystem, len(cfg.Overlays))
+
+	for i, dir := range cfg.Overlays {
+		layers[i] = http.Dir(dir)
+	}
+
+	rewrites := make([]fileserver.RewriteRule, len(cfg.Rewrites))
+
+	for i, rule := range cfg.Rewrites {
+		rewrites[i] = fileserver.RewriteRule{From: rule.From, To: rule.To, Status: rule.Status}
+	}
+
+	authRules := make([]fileserver.AuthRule, len(cfg.Auth))
+
+	for i, rule := range cfg.Auth {
+		authRules[i] = fileserver.AuthRule{Path: rule.Path, Users: rule.Users}
+
+		if rule.HtpasswdFile != "" {
+			realm := rule.Realm
+			if realm == "" {
+				realm = defaultAuthRealm
+			}
+
+			basicAuth, err := fileserver.NewBasicAuth(realm, rule.HtpasswdFile)
+			if err != nil {
+				return fileserver.Settings{}, err
+			}
+
+			authRules[i].Authenticator = basicAuth
+		}
+	}
+
+	write := fileserver.WriteSettings{
+		Enabled:     cfg.Write.Enabled,
+		Paths:       cfg.Write.Paths,
+		Deny:        cfg.Write.Deny,
+		Delete:      cfg.Write.Delete,
+		MaxFileSize: cfg.Write.MaxFileSize,
+		Overwrite:   cfg.Write.Overwrite,
+		Users:       cfg.Write.Users,
+	}
+
+	if cfg.Write.HtpasswdFile != "" {
+		realm := cfg.Write.Realm
+		if realm == "" {
+			realm = defaultAuthRealm
+		}
+
+		basicAuth, err := fileserver.NewBasicAuth(realm, cfg.Write.HtpasswdFile)
+		if err != nil {
+			return fileserver.Settings{}, err
+		}
+
+		write.Authenticator = basicAuth
+	}
+
+	signingKeys := make([]fileserver.SigningKey, len(cfg.SignedURLs.Keys))
+
+	for i, key := range cfg.SignedURLs.Keys {
+		signingKeys[i] = fileserver.SigningKey{ID: key.ID, Secret: key.Secret}
+	}
+
+	bandwidthRules := make([]fileserver.BandwidthRule, len(cfg.Bandwidth.Rules))
+
+	for i, rule := range cfg.Bandwidth.Rules {
+		bandwidthRules[i] = fileserver.BandwidthRule{Path: rule.Path, BytesPerSecond: rule.BytesPerSecond}
+	}
+
+	var headers http.Header
+
+	if cfg.SecureHeaders {
+		headers = fileserver.SecureDefaults()
+	}
+
+	if len(cfg.Headers) > 0 && headers == nil {
+		headers = make(http.Header, len(cfg.Headers))
+	}
+
+	for name, value := range cfg.Headers {
+		headers.Set(name, value)
+	}
+
+	return fileserver.Settings{
+		Layers:            layers,
+		FilesRoot:         cfg.FilesRoot,
+		BasePath:          cfg.BasePath,
+		IndexFileName:     cfg.IndexFileName,
+		ErrorFileName:     cfg.ErrorFileName,
+		ErrorFileNames:    cfg.ErrorFileNames,
+		Languages:         cfg.Languages,
+		RewriteRules:      rewrites,
+		RedirectsFileName: cfg.RedirectsFileName,
+		Headers:           headers,
+		HeadersFileName:   cfg.HeadersFileName,
+		CORS: fileserver.CORSSettings{
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   cfg.CORS.AllowedMethods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   cfg.CORS.ExposedHeaders,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           time.Duration(cfg.CORS.MaxAge),
+		},
+		AuthRules: authRules,
+		SignedURLs: fileserver.SignedURLSettings{
+			Paths:        cfg.SignedURLs.Paths,
+			Keys:         signingKeys,
+			BindClientIP: cfg.SignedURLs.BindClientIP,
+		},
+		RateLimit: fileserver.RateLimitSettings{
+			RequestsPerSecond:        cfg.RateLimit.RequestsPerSecond,
+			Burst:                    cfg.RateLimit.Burst,
+			ClientKeyHeader:          cfg.RateLimit.ClientKeyHeader,
+			MaxClients:               cfg.RateLimit.MaxClients,
+			MaxInFlight:              cfg.RateLimit.MaxInFlight,
+			LargeFileSize:            cfg.RateLimit.LargeFileSize,
+			MaxLargeStreams:          cfg.RateLimit.MaxLargeStreams,
+			MaxLargeStreamsPerClient: cfg.RateLimit.MaxLargeStreamsPerClient,
+		},
+		Bandwidth: fileserver.BandwidthSettings{
+			BytesPerSecond: cfg.Bandwidth.BytesPerSecond,
+			Rules:          bandwidthRules,
+		},
+		Ranges: fileserver.RangeSettings{
+			DisabledPaths: cfg.Ranges.DisabledPaths,
+			MaxRanges:     cfg.Ranges.MaxRanges,
+			Coalesce:      cfg.Ranges.Coalesce,
+		},
+		Write:                   write,
+		WebDAV:                  fileserver.WebDAVSettings{Enabled: cfg.WebDAV.Enabled, ReadOnly: cfg.WebDAV.ReadOnly},
+		RedirectIndexFileToRoot: cfg.RedirectIndexFileToRoot,
+		AllowedHTTPMethods:      cfg.AllowedHTTPMethods,
+		CacheEnabled:            cfg.Cache.Enabled,
+		CacheTTL:                time.Duration(cfg.Cache.TTL),
+		CacheStaleTTL:           time.Duration(cfg.Cache.StaleTTL),
+		CacheRevalidateInterval: time.Duration(cfg.Cache.RevalidateInterval),
+		CacheMaxFileSize:        cfg.Cache.MaxFileSize,
+		CacheMmapMaxFileSize:    cfg.Cache.MmapMaxFileSize,
+		CacheMaxItems:           cfg.Cache.MaxItems,
+		StrictValidation:        cfg.StrictValidation,
+	}, nil
+}
+
+// withSettings returns configuration copy with effective (defaults applied) file server settings.
+func (cfg config) withSettings(s fileserver.Settings) *config {
+	cfg.FilesRoot = s.FilesRoot
+	cfg.Overlays = make([]string, 0, len(s.Layers))
+
+	for _, l := range s.Layers {
+		if dir, ok := l.(http.Dir); ok {
+			cfg.Overlays = append(cfg.Overlays, string(dir))
+		}
+	}
+
+	cfg.BasePath = s.BasePath
+	cfg.IndexFileName = s.IndexFileName
+	cfg.ErrorFileName = s.ErrorFileName
+	cfg.ErrorFileNames = s.ErrorFileNames
+	cfg.Languages = s.Languages
+	cfg.RedirectsFileName = s.RedirectsFileName
+	cfg.RedirectIndexFileToRoot = s.RedirectIndexFileToRoot
+	cfg.AllowedHTTPMethods = s.AllowedHTTPMethods
+	cfg.Cache = cacheConfig{
+		Enabled:            s.CacheEnabled,
+		TTL:                duration(s.CacheTTL),
+		StaleTTL:           duration(s.CacheStaleTTL),
+		RevalidateInterval: duration(s.CacheRevalidateInterval),
+		MaxFileSize:        s.CacheMaxFileSize,
+		MmapMaxFileSize:    s.CacheMmapMaxFileSize,
+		MaxItems:           s.CacheMaxItems,
+	}
+
+	cfg.RateLimit.Burst = s.RateLimit.Burst
+	cfg.RateLimit.MaxClients = s.RateLimit.MaxClients
+	cfg.Write.MaxFileSize = s.Write.MaxFileSize
+
+	// secrets must not be printed
+	keys := make([]signingKeyConfig, len(cfg.SignedURLs.Keys))
+
+	for i, key := range cfg.SignedURLs.Keys {
+		keys[i] = signingKeyConfig{ID: key.ID, Secret: hiddenSecret}
+	}
+
+	cfg.SignedURLs.Keys = keys
+
+	return &cfg
+}
+
+// String returns configuration in YAML format.
+func (cfg *config) String() string {
+	out, _ := yaml.Marshal(cfg)
+
+	return string(out)
+}
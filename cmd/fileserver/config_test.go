@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte(content), 0600))
+
+	return filePath
+}
+
+func noEnv(string) string { return "" }
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	f, err := parseFlags("test", []string{})
+	assert.NoError(t, err)
+
+	cfg, err := loadConfig(f, noEnv)
+	assert.NoError(t, err)
+
+	assert.Equal(t, defaultListen, cfg.Listen)
+	assert.Equal(t, duration(defaultShutdownTimeout), cfg.ShutdownTimeout)
+	assert.False(t, cfg.Cache.Enabled)
+}
+
+func TestLoadConfig_Files(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for _, configFile := range []string{
+		writeFile(t, tmpDir, "config.yml", `
+listen: ":9000"
+files_root: ./web
+error_file: __error__.html
+error_files:
+  404: 404.html
+allowed_methods: [GET, HEAD]
+rewrites:
+  - {from: /old/*, to: /new/:splat, status: 301}
+headers:
+  X-Foo: bar
+cors:
+  allowed_origins: ["https://*.example.com"]
+  max_age: 1h
+tls:
+  cert_file: cert.pem
+  key_file: key.pem
+cache:
+  enabled: true
+  ttl: 1m30s
+  max_items: 512
+`),
+		writeFile(t, tmpDir, "config.toml", `
+listen = ":9000"
+files_root = "./web"
+error_file = "__error__.html"
+allowed_methods = ["GET", "HEAD"]
+rewrites = [{from = "/old/*", to = "/new/:splat", status = 301}]
+headers = {X-Foo = "bar"}
+cors = {allowed_origins = ["https://*.example.com"], max_age = "1h"}
+
+[error_files]
+404 = "404.html"
+
+[tls]
+cert_file = "cert.pem"
+key_file = "key.pem"
+
+[cache]
+enabled = true
+ttl = "1m30s"
+max_items = 512
+`),
+	} {
+		f, err := parseFlags("test", []string{"--config", configFile})
+		assert.NoError(t, err)
+
+		cfg, err := loadConfig(f, noEnv)
+		assert.NoError(t, err, configFile)
+
+		assert.Equal(t, ":9000", cfg.Listen)
+		assert.Equal(t, "./web", cfg.FilesRoot)
+		assert.Equal(t, "__error__.html", cfg.ErrorFileName)
+		assert.Equal(t, map[string]string{"404": "404.html"}, cfg.ErrorFileNames)
+		assert.Equal(t, []string{http.MethodGet, http.MethodHead}, cfg.AllowedHTTPMethods)
+		assert.Equal(t, []rewriteConfig{{From: "/old/*", To: "/new/:splat", Status: 301}}, cfg.Rewrites)
+		assert.Equal(t, map[string]string{"X-Foo": "bar"}, cfg.Headers)
+		assert.Equal(t, corsConfig{AllowedOrigins: []string{"https://*.example.com"}, MaxAge: duration(time.Hour)},
+			cfg.CORS)
+		assert.Equal(t, tlsConfig{CertFile: "cert.pem", KeyFile: "key.pem"}, cfg.TLS)
+		assert.Equal(t, cacheConfig{Enabled: true, TTL: duration(time.Second * 90), MaxItems: 512}, cfg.Cache)
+	}
+
+	for _, configFile := range []string{
+		writeFile(t, tmpDir, "config.json", `{}`),
+		writeFile(t, tmpDir, "broken.yml", `cache: {ttl: foo}`),
+		filepath.Join(tmpDir, "missing.yml"),
+	} {
+		f, _ := parseFlags("test", []string{"--config", configFile})
+
+		_, err := loadConfig(f, noEnv)
+		assert.Error(t, err, configFile)
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	configFile := writeFile(t, tmpDir, "config.yaml", "listen: ':9000'\nfiles_root: ./web\nindex_file: idx.html\n")
+
+	env := map[string]string{
+		"FILESERVER_CONFIG":          configFile,
+		"FILESERVER_LISTEN":          ":9001",
+		"FILESERVER_FILES_ROOT":      "./env",
+		"FILESERVER_CACHE_ENABLED":   "true",
+		"FILESERVER_ALLOWED_METHODS": "GET, HEAD,",
+	}
+
+	f, err := parseFlags("test", []string{"--listen", ":9002", "--cache=false", "--cache-max-items", "10",
+		"--cache-mmap-max-file-size", "1048576", "--cache-stale-ttl", "1m",
+		"--cache-revalidate", "10s"})
+	assert.NoError(t, err)
+
+	cfg, err := loadConfig(f, func(name string) string { return env[name] })
+	assert.NoError(t, err)
+
+	assert.Equal(t, ":9002", cfg.Listen)           // flag
+	assert.Equal(t, "./env", cfg.FilesRoot)        // env
+	assert.Equal(t, "idx.html", cfg.IndexFileName) // file
+	assert.False(t, cfg.Cache.Enabled)             // flag
+	assert.Equal(t, uint32(10), cfg.Cache.MaxItems)
+	assert.Equal(t, int64(1048576), cfg.Cache.MmapMaxFileSize)
+	assert.Equal(t, duration(time.Minute), cfg.Cache.StaleTTL)
+	assert.Equal(t, duration(time.Second*10), cfg.Cache.RevalidateInterval)
+	assert.Equal(t, []string{http.MethodGet, http.MethodHead}, cfg.AllowedHTTPMethods)
+
+	env["FILESERVER_CACHE_TTL"] = "foo"
+	_, err = loadConfig(f, func(name string) string { return env[name] })
+	assert.Error(t, err)
+
+	f, _ = parseFlags("test", []string{"--cache-max-items", "-1"})
+	_, err = loadConfig(f, noEnv)
+	assert.Error(t, err)
+
+	f, _ = parseFlags("test", []string{"--tls-cert", "cert.pem"})
+	_, err = loadConfig(f, noEnv)
+	assert.Error(t, err)
+
+	_, err = parseFlags("test", []string{"--unknown"})
+	assert.Error(t, err)
+}
+
+func TestRun_Check(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	var out bytes.Buffer
+
+	assert.NoError(t, run([]string{"--check", "--root", tmpDir, "--cache", "--overlays", tmpDir + ",foo"}, &out))
+	assert.Contains(t, out.String(), "overlays:\n  - "+tmpDir+"\n  - foo\n")
+	assert.Contains(t, out.String(), "files_root: "+tmpDir)
+	assert.Contains(t, out.String(), "index_file: index.html") // defaults are applied
+	assert.Contains(t, out.String(), "ttl: 5s")
+
+	assert.Error(t, run([]string{"--check", "--root", filepath.Join(tmpDir, "missing")}, &out))
+	assert.NoError(t, run([]string{"--check", "--config", writeFile(t, tmpDir, "auth.yml",
+		"files_root: "+tmpDir+"\nauth: [{path: /*, htpasswd_file: "+writeFile(t, tmpDir, ".htpasswd", "")+"}]\n")}, &out))
+	assert.Contains(t, out.String(), "htpasswd_file: ")
+
+	out.Reset()
+	assert.NoError(t, run([]string{"--check", "--config", writeFile(t, tmpDir, "signed.yml", "files_root: "+tmpDir+
+		"\nsigned_urls: {paths: [/private/*], keys: [{id: k1, secret: top-secret-0123456789}]}\n")}, &out))
+	assert.Contains(t, out.String(), "id: k1")
+	assert.NotContains(t, out.String(), "top-secret", "secrets must not be printed")
+
+	out.Reset()
+	assert.NoError(t, run([]string{"--check", "--config", writeFile(t, tmpDir, "limits.yml", "files_root: "+tmpDir+
+		"\nrate_limit: {requests_per_second: 2.5, max_in_flight: 100}"+
+		"\nbandwidth: {rules: [{path: /downloads/*, bytes_per_second: 2097152}]}"+
+		"\nranges: {disabled_paths: [/video/*], max_ranges: 8}\n")}, &out))
+	assert.Contains(t, out.String(), "burst: 3") // defaults are applied
+	assert.Contains(t, out.String(), "bytes_per_second: 2097152")
+	assert.Contains(t, out.String(), "max_ranges: 8")
+
+	out.Reset()
+	assert.NoError(t, run([]string{"--check", "--config", writeFile(t, tmpDir, "write.yml", "files_root: "+tmpDir+
+		"\nwrite: {enabled: true, paths: [/artifacts/*], htpasswd_file: "+writeFile(t, tmpDir, ".htpasswd", "")+"}\n")},
+		&out))
+	assert.Contains(t, out.String(), "max_file_size: 33554432") // defaults are applied
+
+	stagingDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(stagingDir)
+
+	tusConfig := "files_root: " + tmpDir + "\nwrite: {enabled: true, htpasswd_file: " + tmpDir + "/.htpasswd}" +
+		"\ntus: {base_path: /uploads/, staging_dir: %s}\n"
+
+	out.Reset()
+	assert.NoError(t, run([]string{"--check", "--config", writeFile(t, tmpDir, "tus.yml",
+		fmt.Sprintf(tusConfig, stagingDir))}, &out))
+	assert.Contains(t, out.String(), "base_path: /uploads/")
+	assert.Error(t, run([]string{"--check", "--config", writeFile(t, tmpDir, "tus.yml",
+		fmt.Sprintf(tusConfig, tmpDir))}, &out), "staging directory must be outside the files root")
+
+	out.Reset()
+	assert.NoError(t, run([]string{"--check", "--root", tmpDir, "--webdav"}, &out))
+	assert.Contains(t, out.String(), "webdav:\n    enabled: true\n")
+	assert.Error(t, run([]string{"--check", "--config", writeFile(t, tmpDir, "write.yml",
+		"files_root: "+tmpDir+"\nwrite: {enabled: true}\n")}, &out), "authentication is required")
+	assert.Error(t, run([]string{"--check", "--config", writeFile(t, tmpDir, "auth.yml",
+		"files_root: "+tmpDir+"\nauth: [{path: /*, htpasswd_file: "+filepath.Join(tmpDir, "missing")+"}]\n")}, &out))
+	assert.Error(t, run([]string{"--check", "--root", tmpDir, "--error-file", "missing.html", "--strict"}, &out))
+}
@@ -0,0 +1,137 @@
+// Command fileserver is a standalone static files server, configured using YAML/TOML file, environment variables and
+// command line flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	fileserver "github.com/avto-dev/go-simple-fileserver"
+)
+
+const readHeaderTimeout = time.Second * 10
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+
+		log.Fatal(err)
+	}
+}
+
+// run starts file server and blocks until termination signal is received.
+func run(args []string, out io.Writer) error {
+	f, err := parseFlags("fileserver", args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(f, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	settings, err := cfg.Settings()
+	if err != nil {
+		return fmt.Errorf("wrong configuration: %w", err)
+	}
+
+	fs, err := fileserver.NewFileServer(settings)
+	if err != nil {
+		return fmt.Errorf("wrong configuration: %w", err)
+	}
+
+	handler, err := newHandler(fs, cfg)
+	if err != nil {
+		return fmt.Errorf("wrong configuration: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(out, "Effective configuration:\n%s", cfg.withSettings(fs.CurrentSettings()))
+
+	if f.check {
+		return nil
+	}
+
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return serve(server, cfg)
+}
+
+// newHandler returns file server handler, extended with the resumable uploads handler (when it is configured).
+func newHandler(fs *fileserver.FileServer, cfg *config) (http.Handler, error) {
+	if cfg.Tus.BasePath == "" {
+		return fs, nil
+	}
+
+	tus, err := fileserver.NewTusHandler(fs, fileserver.TusSettings{
+		BasePath:   cfg.Tus.BasePath,
+		StagingDir: cfg.Tus.StagingDir,
+		Expiration: time.Duration(cfg.Tus.Expiration),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tus: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(strings.TrimSuffix(cfg.Tus.BasePath, "/")+"/", tus)
+	mux.Handle("/", fs)
+
+	return mux, nil
+}
+
+// serve starts HTTP(S) server and makes graceful shutdown on SIGINT or SIGTERM.
+func serve(server *http.Server, cfg *config) error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		log.Printf("Listening on %s", server.Addr)
+
+		if cfg.TLS.CertFile != "" {
+			errCh <- server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			errCh <- server.ListenAndServe()
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errCh:
+		return err
+
+	case sig := <-signals:
+		log.Printf("Signal %s received, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,321 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// config is an immutable snapshot of the server settings with derived (prepared in performance reasons) state.
+type config struct {
+	settings Settings
+
+	// Allowed HTTP methods map (is used for fast checking).
+	allowedHTTPMethods map[string]struct{}
+
+	// Value for the `Allow` HTTP header.
+	allowHeader string
+
+	// Files sources, ordered by priority.
+	layers []*layer
+
+	// Compiled rewriting rules from the settings.
+	rewrites []*rewriteRule
+
+	// Redirects file with additional rewriting rules.
+	redirectsFile *watchedFile // nil, if redirects file is not used
+
+	// Global response headers (with canonical names).
+	headers http.Header
+
+	// Compiled response header rules from the settings.
+	headerRules []*headerRule
+
+	// Headers file with additional response header rules.
+	headersFile *watchedFile // nil, if headers file is not used
+
+	// Prepared CORS settings.
+	cors *corsPolicy // nil, if CORS is disabled
+
+	// Compiled authentication rules.
+	authRules []*authRule
+
+	// Prepared signed URLs settings.
+	signer *urlSigner // nil, if signing keys are not set
+
+	// Requests limiter (its state is not shared between config snapshots).
+	limiter *rateLimiter // nil, if limits are not set
+
+	// Bandwidth shaper (its state is not shared between config snapshots).
+	bandwidth *bandwidthShaper // nil, if bandwidth is not limited
+
+	// Prepared range requests policy.
+	ranges *rangePolicy // nil, if default policy is used
+
+	// Prepared write mode settings.
+	writer *fileWriter // nil, if write mode is disabled
+
+	// Prepared WebDAV settings.
+	dav *davConfig // nil, if WebDAV is disabled
+
+	// URL paths of the service files, that must not be served.
+	hiddenPaths map[string]struct{}
+
+	// Settings validation error (requests are answered with the server error, prepared state is empty).
+	err error // nil, if settings are valid
+}
+
+// configContextKey is a request context key for the config snapshot, that is used during request processing.
+type configContextKey struct{}
+
+// newConfig creates config snapshot for the (prepared) settings.
+func newConfig(s Settings) *config {
+	cfg := &config{
+		settings:           s.clone(),
+		allowedHTTPMethods: make(map[string]struct{}, len(s.AllowedHTTPMethods)),
+		layers:             newLayers(s),
+		rewrites:           compileRewriteRules(s.RewriteRules),
+		headerRules:        compileHeaderRules(s.HeaderRules),
+		cors:               newCORSPolicy(s.CORS),
+		authRules:          compileAuthRules(s.AuthRules),
+		signer:             newURLSigner(s.SignedURLs),
+		limiter:            newRateLimiter(s.RateLimit),
+		bandwidth:          newBandwidthShaper(s.Bandwidth),
+		ranges:             newRangePolicy(s.Ranges),
+		writer:             newFileWriter(s),
+		dav:                newDAVConfig(s),
+		hiddenPaths:        make(map[string]struct{}),
+	}
+
+	cfg.headers, _ = canonicalHeaders(s.Headers) // headers are already validated
+
+	if s.RedirectsFileName != "" {
+		cfg.redirectsFile = newWatchedFile(
+			filepath.Join(s.FilesRoot, filepath.FromSlash(s.RedirectsFileName)),
+			func(data []byte) (interface{}, error) { return parseRedirectsFile(data) },
+		)
+		cfg.hiddenPaths[path.Clean("/"+s.RedirectsFileName)] = struct{}{}
+	}
+
+	if s.HeadersFileName != "" {
+		cfg.headersFile = newWatchedFile(
+			filepath.Join(s.FilesRoot, filepath.FromSlash(s.HeadersFileName)),
+			func(data []byte) (interface{}, error) { return parseHeadersFile(data) },
+		)
+		cfg.hiddenPaths[path.Clean("/"+s.HeadersFileName)] = struct{}{}
+	}
+
+	allowed := make([]string, 0, len(s.AllowedHTTPMethods))
+
+	for _, method := range s.AllowedHTTPMethods {
+		if isWriteOnlyMethod(method) && !cfg.writer.handles(method) && !cfg.dav.handles(method) {
+			continue
+		}
+
+		allowed = append(allowed, method)
+	}
+
+	if cfg.writer != nil { // write methods are allowed automatically
+		allowed = append(allowed, cfg.writer.methods...)
+	}
+
+	if cfg.dav != nil { // WebDAV methods are allowed automatically
+		allowed = append(append(allowed, http.MethodOptions), cfg.dav.methods...)
+	}
+
+	for _, method := range allowed {
+		if _, found := cfg.allowedHTTPMethods[method]; !found {
+			cfg.allowedHTTPMethods[method] = struct{}{}
+
+			if cfg.allowHeader != "" {
+				cfg.allowHeader += ", "
+			}
+
+			cfg.allowHeader += method
+		}
+	}
+
+	return cfg
+}
+
+// methodIsAllowed checks HTTP method allowance.
+func (cfg *config) methodIsAllowed(method string) bool {
+	_, found := cfg.allowedHTTPMethods[method]
+
+	return found
+}
+
+// isHiddenPath checks that URL path points to the service file, that must not be served.
+func (cfg *config) isHiddenPath(urlPath string) bool {
+	_, hidden := cfg.hiddenPaths[path.Clean(urlPath)]
+
+	return hidden
+}
+
+// cacheKey returns cache key for the file path.
+func (cfg *config) cacheKey(filePath string) string {
+	return cfg.settings.CacheNamespace + filePath
+}
+
+// clone makes settings copy, that does not share slices and maps with the original.
+func (s Settings) clone() Settings {
+	c := s
+
+	c.AllowedHTTPMethods = append([]string(nil), s.AllowedHTTPMethods...)
+	c.Languages = append([]string(nil), s.Languages...)
+	c.Layers = append([]http.FileSystem(nil), s.Layers...)
+	c.RewriteRules = append([]RewriteRule(nil), s.RewriteRules...)
+	c.Headers = s.Headers.Clone()
+	c.CORS = s.CORS.clone()
+	c.SignedURLs = s.SignedURLs.clone()
+	c.Bandwidth = s.Bandwidth.clone()
+	c.Ranges = s.Ranges.clone()
+	c.Write = s.Write.clone()
+
+	if s.AuthRules != nil {
+		c.AuthRules = make([]AuthRule, len(s.AuthRules))
+
+		for i, rule := range s.AuthRules {
+			rule.Users = append([]string(nil), rule.Users...)
+			c.AuthRules[i] = rule
+		}
+	}
+
+	if s.HeaderRules != nil {
+		c.HeaderRules = make([]HeaderRule, len(s.HeaderRules))
+
+		for i, rule := range s.HeaderRules {
+			c.HeaderRules[i] = HeaderRule{Path: rule.Path, Headers: rule.Headers.Clone()}
+		}
+	}
+
+	if s.ErrorFileNames != nil {
+		c.ErrorFileNames = make(map[string]string, len(s.ErrorFileNames))
+
+		for k, v := range s.ErrorFileNames {
+			c.ErrorFileNames[k] = v
+		}
+	}
+
+	return c
+}
+
+// prepareSettings validates settings and fills empty values with defaults.
+func prepareSettings(s Settings) (Settings, error) {
+	if err := s.Validate(); err != nil {
+		return s, err
+	}
+
+	if s.IndexFileName == "" {
+		s.IndexFileName = defaultIndexFileName
+	}
+
+	s.BasePath = strings.TrimRight(s.BasePath, "/")
+
+	if s.CacheTTL == 0 {
+		s.CacheTTL = defaultCacheTTL
+	}
+
+	if s.CacheMaxFileSize == 0 {
+		s.CacheMaxFileSize = defaultCacheMaxFileSize
+	}
+
+	if s.CacheMaxItems == 0 {
+		s.CacheMaxItems = defaultCacheMaxItems
+	}
+
+	if len(s.AllowedHTTPMethods) == 0 {
+		s.AllowedHTTPMethods = []string{http.MethodGet}
+	}
+
+	if s.RateLimit.enabled() {
+		s.RateLimit = s.RateLimit.withDefaults()
+	}
+
+	if s.Write.Enabled {
+		s.Write = s.Write.withDefaults()
+	}
+
+	return s, nil
+}
+
+// CurrentSettings returns a copy of current server settings.
+func (fs *FileServer) CurrentSettings() Settings {
+	return fs.config().settings.clone()
+}
+
+// UpdateSettings validates passed settings and atomically replaces current server settings. Requests in progress will
+// be finished using previous settings. Caching can not be enabled in runtime, if server was created without cacher.
+func (fs *FileServer) UpdateSettings(s Settings) error {
+	s, err := prepareSettings(s)
+	if err != nil {
+		return err
+	}
+
+	if s.CacheEnabled && fs.Cache == nil {
+		return errors.New("caching can not be enabled without cacher instance")
+	}
+
+	fs.cfgMu.Lock()
+	fs.storeConfig(newConfig(s))
+	fs.cfgMu.Unlock()
+
+	return nil
+}
+
+// storeConfig replaces current config snapshot (must be called with locked cfgMu).
+func (fs *FileServer) storeConfig(cfg *config) {
+	if prev, ok := fs.cfg.Load().(*config); ok {
+		reuseLayerIDs(cfg.layers, prev.layers)
+	}
+
+	fs.cfg.Store(cfg)
+}
+
+// config returns current config snapshot. Until `UpdateSettings` is called, snapshot is created from the deprecated
+// `FileServer.Settings` field, when server is used first time (later field changes are not applied).
+func (fs *FileServer) config() *config {
+	if cfg, ok := fs.cfg.Load().(*config); ok {
+		return cfg
+	}
+
+	fs.cfgMu.Lock()
+	defer fs.cfgMu.Unlock()
+
+	if cfg, ok := fs.cfg.Load().(*config); ok { // snapshot was created concurrently
+		return cfg
+	}
+
+	cfg := newFieldConfig(fs.Settings)
+	fs.storeConfig(cfg)
+
+	return cfg
+}
+
+// newFieldConfig creates config snapshot for the deprecated `FileServer.Settings` field value. Invalid value never
+// becomes a live config (requests are answered with the server error).
+func newFieldConfig(field Settings) *config {
+	s, err := prepareSettings(field)
+	if err != nil {
+		return &config{settings: field.clone(), err: err}
+	}
+
+	return newConfig(s)
+}
+
+// configFor returns config snapshot, that is used for the request processing.
+func (fs *FileServer) configFor(r *http.Request) *config {
+	if cfg, ok := r.Context().Value(configContextKey{}).(*config); ok {
+		return cfg
+	}
+
+	return fs.config()
+}
+
+// withConfig attaches config snapshot to the request.
+func withConfig(r *http.Request, cfg *config) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), configContextKey{}, cfg))
+}
@@ -0,0 +1,244 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileServer_UpdateSettings(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "test"), []byte("test content"), 0600))
+
+	fs, _ := NewFileServer(Settings{FilesRoot: tmpDir})
+	assert.NotNil(t, fs)
+
+	serve := func(method string) int {
+		var (
+			req, _ = http.NewRequest(method, "/test", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		fs.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet))
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPost))
+
+	settings := fs.CurrentSettings()
+	settings.AllowedHTTPMethods = []string{http.MethodPost}
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost))
+
+	// returned settings are a copy
+	fs.CurrentSettings().AllowedHTTPMethods[0] = http.MethodGet
+	assert.Equal(t, []string{http.MethodPost}, fs.CurrentSettings().AllowedHTTPMethods)
+
+	// wrong settings are not applied
+	settings.FilesRoot = filepath.Join(tmpDir, "missing")
+	assert.Error(t, fs.UpdateSettings(settings))
+	assert.Equal(t, tmpDir, fs.CurrentSettings().FilesRoot)
+
+	settings = fs.CurrentSettings()
+	settings.CacheEnabled = true
+	assert.Error(t, fs.UpdateSettings(settings))
+	assert.False(t, fs.CacheAvailable())
+
+	// defaults are applied
+	assert.NoError(t, fs.UpdateSettings(Settings{FilesRoot: tmpDir}))
+	assert.Equal(t, []string{http.MethodGet}, fs.CurrentSettings().AllowedHTTPMethods)
+	assert.Equal(t, defaultIndexFileName, fs.CurrentSettings().IndexFileName)
+}
+
+func TestFileServer_SettingsField(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "test"), []byte("test content"), 0600))
+
+	serve := func(fs *FileServer, method string) int {
+		var (
+			req, _ = http.NewRequest(method, "/test", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		fs.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	// server without constructor
+	fs := &FileServer{Settings: Settings{FilesRoot: tmpDir, AllowedHTTPMethods: []string{http.MethodGet}}}
+
+	assert.Equal(t, http.StatusOK, serve(fs, http.MethodGet))
+	assert.Equal(t, defaultIndexFileName, fs.CurrentSettings().IndexFileName, "defaults are applied")
+
+	// field changes are applied before the first usage only
+	fs, _ = NewFileServer(Settings{FilesRoot: tmpDir})
+	assert.Equal(t, []string{http.MethodGet}, fs.Settings.AllowedHTTPMethods)
+
+	fs.Settings.AllowedHTTPMethods = []string{http.MethodPost}
+	assert.Equal(t, http.StatusOK, serve(fs, http.MethodPost))
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(fs, http.MethodGet))
+
+	fs.Settings.AllowedHTTPMethods = []string{http.MethodGet}
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(fs, http.MethodGet))
+
+	// settings are changed using the settings updating
+	assert.NoError(t, fs.UpdateSettings(Settings{FilesRoot: tmpDir}))
+	assert.Equal(t, http.StatusOK, serve(fs, http.MethodGet))
+}
+
+func TestFileServer_InvalidSettingsField(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "test"), []byte("test content"), 0600))
+
+	serve := func(fs *FileServer, method, url string) int {
+		var (
+			req, _ = http.NewRequest(method, url, strings.NewReader("uploaded"))
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("X-User", "ci")
+		fs.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	// server without constructor (write mode without authenticator)
+	fs := &FileServer{Settings: Settings{FilesRoot: tmpDir, Write: WriteSettings{Enabled: true}}}
+
+	assert.Equal(t, http.StatusInternalServerError, serve(fs, http.MethodPut, "/anonymous"))
+	assert.Equal(t, http.StatusInternalServerError, serve(fs, http.MethodGet, "/test"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "anonymous"))
+
+	fs = &FileServer{Settings: Settings{FilesRoot: tmpDir, Write: WriteSettings{Enabled: true, Users: []string{"ci"}}}}
+
+	assert.NotPanics(t, func() {
+		assert.Equal(t, http.StatusInternalServerError, serve(fs, http.MethodPut, "/users"))
+	})
+	assert.NoFileExists(t, filepath.Join(tmpDir, "users"))
+
+	// constructed server, which field is changed to invalid value before the first usage
+	fs, _ = NewFileServer(Settings{FilesRoot: tmpDir})
+	fs.Settings.Write = WriteSettings{Enabled: true}
+
+	assert.Equal(t, http.StatusInternalServerError, serve(fs, http.MethodPut, "/changed"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "changed"))
+}
+
+func TestFileServer_UpdateSettings_InFlightRequest(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "old.html"), []byte("old {{ code }}"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "new.html"), []byte("new {{ code }}"), 0600))
+
+	fs, _ := NewFileServer(Settings{FilesRoot: tmpDir, ErrorFileName: "old.html"})
+	assert.NotNil(t, fs)
+
+	var started, updated = make(chan struct{}), make(chan struct{})
+
+	fs.ErrorHandlers = []ErrorHandlerFunc{
+		func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
+			close(started)
+			<-updated // settings are changed during the request processing
+
+			return false
+		},
+		StaticHTMLPageErrorHandler(),
+	}
+
+	var (
+		req, _ = http.NewRequest(http.MethodGet, "/missing", nil)
+		rr     = httptest.NewRecorder()
+		done   = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		fs.ServeHTTP(rr, req)
+	}()
+
+	<-started
+
+	settings := fs.CurrentSettings()
+	settings.ErrorFileName = "new.html"
+	assert.NoError(t, fs.UpdateSettings(settings))
+	close(updated)
+
+	<-done
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "old 404", rr.Body.String())
+	assert.Equal(t, "new.html", fs.CurrentSettings().ErrorFileName)
+}
+
+func TestFileServer_UpdateSettings_Concurrent(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "test"), []byte("test content"), 0600))
+
+	fs, _ := NewFileServer(Settings{FilesRoot: tmpDir, CacheEnabled: true})
+	assert.NotNil(t, fs)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				var (
+					req, _ = http.NewRequest(http.MethodGet, "/test", nil)
+					rr     = httptest.NewRecorder()
+				)
+
+				fs.ServeHTTP(rr, req)
+
+				if rr.Code != http.StatusOK && rr.Code != http.StatusMethodNotAllowed {
+					t.Errorf("unexpected response code %d", rr.Code)
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for j := 0; j < 100; j++ {
+			settings := fs.CurrentSettings()
+
+			if j%2 == 0 {
+				settings.AllowedHTTPMethods = []string{http.MethodPost}
+			} else {
+				settings.AllowedHTTPMethods = []string{http.MethodGet, http.MethodHead}
+			}
+
+			assert.NoError(t, fs.UpdateSettings(settings))
+		}
+	}()
+
+	wg.Wait()
+}
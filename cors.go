@@ -0,0 +1,277 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSSettings describes cross-origin resource sharing (CORS) options. CORS is disabled, when allowed origins list is
+// empty.
+type CORSSettings struct {
+	// Allowed origins: exact origin (`https://example.com`), wildcard (`https://*.example.com`), regular expression
+	// (when it starts with `^`) or `*` for any origin.
+	AllowedOrigins []string
+
+	// Methods, that are allowed for the cross-origin requests (`GET` and `HEAD` by default).
+	AllowedMethods []string
+
+	// Request headers, that are allowed for the cross-origin requests (`*` allows any header).
+	AllowedHeaders []string
+
+	// Response headers, that are exposed to the cross-origin requests.
+	ExposedHeaders []string
+
+	// Allows requests with credentials (cookies, authorization headers). Can not be used, when any origin (`*`) is
+	// allowed.
+	AllowCredentials bool
+
+	// Preflight response caching lifetime (is not sent, when zero).
+	MaxAge time.Duration
+}
+
+// enabled checks that CORS is enabled.
+func (s CORSSettings) enabled() bool { return len(s.AllowedOrigins) > 0 }
+
+// clone returns deep copy of the CORS settings.
+func (s CORSSettings) clone() CORSSettings {
+	s.AllowedOrigins = append([]string(nil), s.AllowedOrigins...)
+	s.AllowedMethods = append([]string(nil), s.AllowedMethods...)
+	s.AllowedHeaders = append([]string(nil), s.AllowedHeaders...)
+	s.ExposedHeaders = append([]string(nil), s.ExposedHeaders...)
+
+	return s
+}
+
+// validate checks CORS settings.
+func (s CORSSettings) validate(errs *ValidationErrors) {
+	anyOrigin := false
+
+	for i, origin := range s.AllowedOrigins {
+		if _, err := compileOriginPattern(origin); err != nil {
+			errs.add(fmt.Sprintf("CORS.AllowedOrigins[%d]", i), err.Error())
+		}
+
+		anyOrigin = anyOrigin || origin == "*"
+	}
+
+	if anyOrigin && s.AllowCredentials {
+		errs.add("CORS.AllowCredentials", "credentials can not be allowed for any origin")
+	}
+
+	for i, method := range s.AllowedMethods {
+		if !isKnownHTTPMethod(method) {
+			errs.add(fmt.Sprintf("CORS.AllowedMethods[%d]", i), `unknown HTTP method "%s"`, method)
+		}
+	}
+
+	if s.MaxAge < 0 {
+		errs.add("CORS.MaxAge", "must not be negative")
+	}
+}
+
+// corsPolicy is a prepared (for the fast requests checking) CORS settings.
+type corsPolicy struct {
+	anyOrigin      bool
+	origins        map[string]struct{}
+	originPatterns []*regexp.Regexp
+	methods        map[string]struct{}
+	methodsHeader  string
+	anyHeader      bool
+	headers        map[string]struct{} // canonical header names
+	exposedHeaders string
+	credentials    bool
+	maxAge         string // empty, if max age is not set
+}
+
+// compileOriginPattern compiles wildcard (`https://*.example.com`) or regular expression (`^https://.+$`) origin
+// pattern. Nil is returned for the exact origin.
+func compileOriginPattern(pattern string) (*regexp.Regexp, error) {
+	switch {
+	case pattern == "":
+		return nil, errors.New("origin must not be empty")
+
+	case strings.HasPrefix(pattern, "^"):
+		return regexp.Compile(pattern)
+
+	case pattern != "*" && strings.Contains(pattern, "*"):
+		quoted := regexp.QuoteMeta(strings.ToLower(pattern))
+
+		return regexp.Compile("^" + strings.ReplaceAll(quoted, `\*`, `[a-z0-9.-]+`) + "$")
+	}
+
+	return nil, nil
+}
+
+// newCORSPolicy prepares (already validated) CORS settings. Nil is returned, when CORS is disabled.
+func newCORSPolicy(s CORSSettings) *corsPolicy {
+	if !s.enabled() {
+		return nil
+	}
+
+	p := &corsPolicy{
+		origins:        make(map[string]struct{}),
+		methods:        make(map[string]struct{}),
+		headers:        make(map[string]struct{}),
+		exposedHeaders: strings.Join(s.ExposedHeaders, ", "),
+	}
+
+	for _, origin := range s.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+
+			continue
+		}
+
+		if re, err := compileOriginPattern(origin); err == nil && re != nil {
+			p.originPatterns = append(p.originPatterns, re)
+		} else if err == nil {
+			p.origins[strings.ToLower(origin)] = struct{}{}
+		}
+	}
+
+	methods := s.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	for _, method := range methods {
+		p.methods[method] = struct{}{}
+	}
+
+	p.credentials = s.AllowCredentials && !p.anyOrigin // credentials are never sent with `*`
+
+	p.methodsHeader = strings.Join(methods, ", ")
+
+	for _, header := range s.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+		} else {
+			p.headers[http.CanonicalHeaderKey(header)] = struct{}{}
+		}
+	}
+
+	if s.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(s.MaxAge / time.Second))
+	}
+
+	return p
+}
+
+// originIsAllowed checks the request origin.
+func (p *corsPolicy) originIsAllowed(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+
+	if _, ok := p.origins[origin]; ok {
+		return true
+	}
+
+	for _, re := range p.originPatterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headersAreAllowed checks headers list from the `Access-Control-Request-Headers` preflight request header.
+func (p *corsPolicy) headersAreAllowed(list string) bool {
+	if p.anyHeader {
+		return true
+	}
+
+	for _, header := range strings.Split(list, ",") {
+		if header = strings.TrimSpace(header); header == "" {
+			continue
+		}
+
+		if _, ok := p.headers[http.CanonicalHeaderKey(header)]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPreflight checks that request is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// setAllowOrigin sets `Access-Control-Allow-Origin` (and credentials) response headers for the allowed origin.
+func (p *corsPolicy) setAllowOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// handleCORS sets CORS response headers and answers preflight requests (`true` is returned in this case). `Vary:
+// Origin` header is set for every response (even without `Origin` request header, so shared caches do not mix up
+// responses), when the response depends on the request origin.
+func (fs *FileServer) handleCORS(w http.ResponseWriter, r *http.Request, p *corsPolicy) bool {
+	var (
+		h            = w.Header()
+		origin       = r.Header.Get("Origin")
+		originVaries = !p.anyOrigin
+	)
+
+	if originVaries {
+		h.Add("Vary", "Origin")
+	}
+
+	if !isPreflight(r) {
+		if !originVaries || (origin != "" && p.originIsAllowed(origin)) {
+			p.setAllowOrigin(h, origin)
+
+			if p.exposedHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", p.exposedHeaders)
+			}
+		}
+
+		return false
+	}
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+
+	if _, methodAllowed := p.methods[r.Header.Get("Access-Control-Request-Method")]; !methodAllowed ||
+		!p.originIsAllowed(origin) || !p.headersAreAllowed(requestHeaders) {
+		fs.handleError(w, r, http.StatusForbidden)
+
+		return true
+	}
+
+	p.setAllowOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.methodsHeader)
+
+	if requestHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return true
+}
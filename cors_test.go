@@ -0,0 +1,177 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileServer_ServeHTTP_CORS(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "font.woff2"), []byte("font"), 0600))
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:    tmpDir,
+		CacheEnabled: true,
+		CORS: CORSSettings{
+			AllowedOrigins:   []string{"https://example.com", "https://*.example.org", `^https://app\d+\.test$`},
+			AllowedHeaders:   []string{"x-requested-with"},
+			ExposedHeaders:   []string{"Content-Length"},
+			AllowCredentials: true,
+			MaxAge:           time.Hour,
+		},
+	})
+	assert.NoError(t, err)
+
+	serve := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(method, "/font.woff2", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for i := 0; i < 2; i++ { // second iteration uses cache
+		for origin, allowed := range map[string]bool{
+			"https://example.com":           true,
+			"https://EXAMPLE.com":           true,
+			"https://cdn.example.org":       true,
+			"https://a.b.example.org":       true,
+			"https://example.org":           false,
+			"https://evil.com/.example.org": false,
+			"https://app42.test":            true,
+			"https://appx.test":             false,
+			"http://example.com":            false,
+		} {
+			rr := serve(http.MethodGet, origin, nil)
+
+			assert.Equal(t, http.StatusOK, rr.Code, origin)
+			assert.Equal(t, []string{"Origin"}, rr.Header()["Vary"], origin)
+
+			if allowed {
+				assert.Equal(t, origin, rr.Header().Get("Access-Control-Allow-Origin"), origin)
+				assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"), origin)
+				assert.Equal(t, "Content-Length", rr.Header().Get("Access-Control-Expose-Headers"), origin)
+			} else {
+				assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"), origin)
+			}
+		}
+	}
+
+	// request without origin varies too
+	rr := serve(http.MethodGet, "", nil)
+	assert.Equal(t, []string{"Origin"}, rr.Header()["Vary"])
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+
+	// preflight is answered, even if OPTIONS method is not allowed
+	rr = serve(http.MethodOptions, "https://example.com", map[string]string{
+		"Access-Control-Request-Method":  http.MethodGet,
+		"Access-Control-Request-Headers": "X-Requested-With",
+	})
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "X-Requested-With", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", rr.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, rr.Header()["Vary"], "Access-Control-Request-Method")
+
+	for _, headers := range []map[string]string{
+		{"Access-Control-Request-Method": http.MethodPut},
+		{"Access-Control-Request-Method": http.MethodGet, "Access-Control-Request-Headers": "Authorization"},
+	} {
+		rr = serve(http.MethodOptions, "https://example.com", headers)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	rr = serve(http.MethodOptions, "https://evil.com", map[string]string{"Access-Control-Request-Method": "GET"})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// not a preflight request
+	rr = serve(http.MethodOptions, "https://example.com", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "https://example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestFileServer_ServeHTTP_CORSAnyOrigin(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	fs, err := NewFileServer(Settings{FilesRoot: tmpDir, CORS: CORSSettings{AllowedOrigins: []string{"*"}}})
+	assert.NoError(t, err)
+
+	for _, origin := range []string{"", "https://example.com"} {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, "/missing", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("Origin", origin)
+		fs.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.NotContains(t, rr.Header()["Vary"], "Origin")
+	}
+}
+
+func TestFileServer_ServeHTTP_CORSVaryHeader(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot: tmpDir,
+		Headers:   http.Header{"Vary": {"Accept-Encoding"}},
+		CORS:      CORSSettings{AllowedOrigins: []string{"https://example.com"}},
+	})
+	assert.NoError(t, err)
+
+	var (
+		req, _ = http.NewRequest(http.MethodGet, "/missing", nil)
+		rr     = httptest.NewRecorder()
+	)
+
+	req.Header.Set("Origin", "https://example.com")
+	fs.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Header()["Vary"], "Accept-Encoding")
+	assert.Contains(t, rr.Header()["Vary"], "Origin", "error response varies by origin")
+}
+
+func TestSettings_Validate_CORS(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{FilesRoot: tmpDir, CORS: CORSSettings{
+		AllowedOrigins: []string{"https://example.com", "", "^(("},
+		AllowedMethods: []string{"GET", "FOO"},
+		MaxAge:         -time.Second,
+	}}.Validate()
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"CORS.AllowedOrigins[1]", "CORS.AllowedOrigins[2]", "CORS.AllowedMethods[1]",
+		"CORS.MaxAge"}, err.(ValidationErrors).Fields())
+
+	err = Settings{FilesRoot: tmpDir, CORS: CORSSettings{AllowedOrigins: []string{"*"}, AllowCredentials: true}}.Validate()
+	assert.EqualError(t, err, "wrong settings: CORS.AllowCredentials: credentials can not be allowed for any origin")
+}
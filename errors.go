@@ -3,20 +3,20 @@ package fileserver
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"path"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/avto-dev/go-simple-fileserver/cache"
 )
 
 // ErrorPageTemplate  is error page template in string representation. Is allowed to use basic "replacing patterns"
-// like `{{ code }}` or `{{ message }}`
+// like `{{ code }}`, `{{ message }}` or `{{ base_path }}`
 type ErrorPageTemplate string
 
 // String converts template into string representation.
@@ -24,82 +24,207 @@ func (t ErrorPageTemplate) String() string { return string(t) }
 
 // Build makes registered patterns replacing.
 func (t ErrorPageTemplate) Build(errorCode int) string {
-	out := t.String()
+	return t.BuildMessage(errorCode, http.StatusText(errorCode))
+}
 
-	for k, v := range map[string]string{
+// BuildMessage makes registered patterns replacing using passed (eg. localized) message.
+func (t ErrorPageTemplate) BuildMessage(errorCode int, message string) string {
+	return t.Render(map[string]string{
 		"code":    strconv.Itoa(errorCode),
-		"message": http.StatusText(errorCode),
-	} {
+		"message": message,
+	})
+}
+
+// Render replaces `{{ name }}` patterns with passed values.
+func (t ErrorPageTemplate) Render(vars map[string]string) string {
+	out := t.String()
+
+	for k, v := range vars {
 		out = strings.ReplaceAll(out, fmt.Sprintf("{{ %s }}", k), v)
 	}
 
 	return out
 }
 
+// errorPageVars returns error page template variables for the request.
+func (fs *FileServer) errorPageVars(r *http.Request, errorCode int) map[string]string {
+	return map[string]string{
+		"code":      strconv.Itoa(errorCode),
+		"message":   fs.StatusMessage(r, errorCode),
+		"base_path": fs.configFor(r).settings.BasePath,
+	}
+}
+
+// Media types of the built-in error renderers.
+const (
+	MediaTypeHTML        = "text/html"
+	MediaTypeJSON        = "application/json"
+	MediaTypeProblemJSON = "application/problem+json"
+	MediaTypeXML         = "application/xml"
+	MediaTypePlainText   = "text/plain"
+)
+
+// ErrorRenderer renders error response using some media type.
+type ErrorRenderer struct {
+	// Media type, that is produced by the renderer (eg.: `application/json`).
+	MediaType string
+
+	// Renderer function. If it returns `false` - error response will be produced by the next error handlers.
+	Render ErrorHandlerFunc
+}
+
+// DefaultErrorRenderers returns built-in error renderers list (ordered by server preference).
+func DefaultErrorRenderers() []ErrorRenderer {
+	return []ErrorRenderer{
+		{MediaType: MediaTypeHTML, Render: StaticHTMLPageErrorHandler()},
+		{MediaType: MediaTypeJSON, Render: JSONErrorRenderer()},
+		{MediaType: MediaTypeProblemJSON, Render: ProblemJSONErrorRenderer()},
+		{MediaType: MediaTypeXML, Render: XMLErrorRenderer()},
+		{MediaType: MediaTypePlainText, Render: PlainTextErrorRenderer()},
+	}
+}
+
+// NegotiatedErrorHandler selects the best error renderer (from `FileServer.ErrorRenderers`) for the media types,
+// requested in `Accept` header (quality values are respected), and renders error response using it.
+func NegotiatedErrorHandler() ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
+		offers := make([]string, len(fs.ErrorRenderers))
+
+		for i, renderer := range fs.ErrorRenderers {
+			offers[i] = renderer.MediaType
+		}
+
+		w.Header().Add("Vary", "Accept")
+
+		if len(fs.configFor(r).settings.Languages) > 0 {
+			w.Header().Add("Vary", "Accept-Language")
+		}
+
+		if mediaType, ok := negotiateMediaType(r.Header.Get("Accept"), offers); ok {
+			for _, renderer := range fs.ErrorRenderers {
+				if renderer.MediaType == mediaType {
+					return renderer.Render(w, r, fs, errorCode)
+				}
+			}
+		}
+
+		return false
+	}
+}
+
 type jsonError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 }
 
 // JSONErrorHandler respond with simple json-formatted response, if json format was requested (defined in `Accept`
-// header).
+// header) and preferred over HTML.
 func JSONErrorHandler() ErrorHandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
-		if strings.Contains(r.Header.Get("Accept"), "json") {
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.WriteHeader(errorCode)
+	render := JSONErrorRenderer()
 
-			_ = json.NewEncoder(w).Encode(jsonError{
-				Code:    errorCode,
-				Message: http.StatusText(errorCode),
-			})
+	return func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
+		accept := r.Header.Get("Accept")
 
-			return true
+		if accept != "" {
+			if mediaType, ok := negotiateMediaType(accept, []string{MediaTypeHTML, MediaTypeJSON}); ok &&
+				mediaType == MediaTypeJSON {
+				return render(w, r, fs, errorCode)
+			}
 		}
 
 		return false
 	}
 }
 
-// StaticHTMLPageErrorHandler allows to use user-defined local file with HTML for error page generating.
-func StaticHTMLPageErrorHandler() ErrorHandlerFunc { //nolint:gocognit
+// JSONErrorRenderer renders error using simple json-formatted response.
+func JSONErrorRenderer() ErrorHandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
-		if len(fs.Settings.ErrorFileName) > 0 {
-			var (
-				filePath        = path.Join(fs.Settings.FilesRoot, fs.Settings.ErrorFileName)
-				templateContent []byte
-				loaded          bool
-			)
-
-			if fs.CacheAvailable() {
-				if cached, cacheHit := fs.Cache.Get(filePath); cacheHit {
-					templateContent, _ = ioutil.ReadAll(cached.Content)
-					loaded = true
-				}
-			}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(errorCode)
 
-			if !loaded {
-				if f, err := os.Open(filePath); err == nil {
-					defer f.Close()
-
-					if data, err := ioutil.ReadAll(f); err == nil {
-						templateContent = data
-						loaded = true
-
-						if fs.CacheAvailable() && fs.Cache.Count() < fs.Settings.CacheMaxItems {
-							fs.Cache.Set(filePath, fs.Settings.CacheTTL, &cache.Item{
-								ModifiedTime: time.Now(),
-								Content:      bytes.NewReader(data),
-							})
-						}
-					}
-				}
-			}
+		_ = json.NewEncoder(w).Encode(jsonError{
+			Code:    errorCode,
+			Message: fs.StatusMessage(r, errorCode),
+		})
+
+		return true
+	}
+}
+
+type problemError struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// ProblemJSONErrorRenderer renders error using "problem details" format (RFC 7807).
+func ProblemJSONErrorRenderer() ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
+		w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+		w.WriteHeader(errorCode)
 
-			if loaded {
+		_ = json.NewEncoder(w).Encode(problemError{
+			Type:     "about:blank",
+			Title:    fs.StatusMessage(r, errorCode),
+			Status:   errorCode,
+			Instance: r.URL.Path,
+		})
+
+		return true
+	}
+}
+
+type xmlError struct {
+	XMLName xml.Name `xml:"error"`
+	Code    int      `xml:"code"`
+	Message string   `xml:"message"`
+}
+
+// XMLErrorRenderer renders error using simple xml-formatted response.
+func XMLErrorRenderer() ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(errorCode)
+
+		_, _ = io.WriteString(w, xml.Header)
+		_ = xml.NewEncoder(w).Encode(xmlError{
+			Code:    errorCode,
+			Message: fs.StatusMessage(r, errorCode),
+		})
+
+		return true
+	}
+}
+
+// PlainTextErrorRenderer renders error using plain text response (like `404 Not Found`).
+func PlainTextErrorRenderer() ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(errorCode)
+
+		_, _ = fmt.Fprintf(w, "%d %s\n", errorCode, fs.StatusMessage(r, errorCode))
+
+		return true
+	}
+}
+
+// StaticHTMLPageErrorHandler allows to use user-defined local file with HTML for error page generating. Template file
+// is resolved for the exact status code first, then for the status class (`Settings.ErrorFileNames`) and then
+// `Settings.ErrorFileName` is used. Localized template (like `__error__.ru.html`) is preferred, when request language
+// is negotiated. Every template is loaded and cached independently.
+func StaticHTMLPageErrorHandler() ErrorHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, fs *FileServer, errorCode int) bool {
+		var (
+			cfg  = fs.configFor(r)
+			lang = fs.RequestLanguage(r)
+		)
+
+		for _, fileName := range errorFileNamesFor(cfg.settings, errorCode, lang) {
+			if templateContent, loaded := loadErrorTemplate(fs, cfg, fileName); loaded {
 				w.Header().Set("Content-Type", "text/html; charset=utf-8")
 				w.WriteHeader(errorCode)
-				_, _ = w.Write([]byte(ErrorPageTemplate(templateContent).Build(errorCode)))
+				_, _ = w.Write([]byte(ErrorPageTemplate(templateContent).Render(fs.errorPageVars(r, errorCode))))
 
 				return true
 			}
@@ -108,3 +233,65 @@ func StaticHTMLPageErrorHandler() ErrorHandlerFunc { //nolint:gocognit
 		return false
 	}
 }
+
+// errorFileNamesFor returns error page template file names (ordered by priority) for passed HTTP status code. When
+// language is not empty - localized file name precedes each file name.
+func errorFileNamesFor(s Settings, errorCode int, lang string) []string {
+	names := make([]string, 0, 6) //nolint:gomnd
+
+	if len(s.ErrorFileNames) > 0 {
+		code := strconv.Itoa(errorCode)
+
+		for _, key := range []string{code, code[:1] + "xx"} {
+			if name, ok := s.ErrorFileNames[key]; ok && len(name) > 0 {
+				names = appendLocalizedFileName(names, name, lang)
+			}
+		}
+	}
+
+	if len(s.ErrorFileName) > 0 {
+		names = appendLocalizedFileName(names, s.ErrorFileName, lang)
+	}
+
+	return names
+}
+
+// appendLocalizedFileName appends localized (`__error__.ru.html`) and original (`__error__.html`) file names.
+func appendLocalizedFileName(names []string, name, lang string) []string {
+	if lang != "" {
+		ext := path.Ext(name)
+		names = append(names, name[:len(name)-len(ext)]+"."+lang+ext)
+	}
+
+	return append(names, name)
+}
+
+// loadErrorTemplate reads error page template content from the cache (if it is possible) or from the file layers.
+func loadErrorTemplate(fs *FileServer, cfg *config, name string) ([]byte, bool) {
+	resolved, err := fs.resolveFile(cfg, name)
+	if err != nil {
+		return nil, false
+	}
+
+	defer resolved.Close()
+
+	if resolved.cached != nil {
+		templateContent, _ := ioutil.ReadAll(cachedContent(resolved.cached))
+
+		return templateContent, true
+	}
+
+	data, err := ioutil.ReadAll(resolved.file)
+	if err != nil {
+		return nil, false
+	}
+
+	if fs.cacheAvailable(cfg) && fs.Cache.Count() < cfg.settings.CacheMaxItems {
+		fs.Cache.Set(cfg.cacheKey(resolved.layer.key(resolved.name)), cfg.settings.CacheTTL, &cache.Item{
+			ModifiedTime: resolved.info.ModTime(),
+			Content:      bytes.NewReader(data),
+		})
+	}
+
+	return data, true
+}
@@ -1,6 +1,7 @@
 package fileserver
 
 import (
+	"encoding/xml"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -23,6 +24,16 @@ func TestErrorPageTemplate_Build(t *testing.T) {
 	)
 }
 
+func TestErrorPageTemplate_Render(t *testing.T) {
+	assert.Equal(t,
+		"/static 404 {{ foo }}",
+		ErrorPageTemplate("{{ base_path }} {{ code }} {{ foo }}").Render(map[string]string{
+			"base_path": "/static",
+			"code":      "404",
+		}),
+	)
+}
+
 func TestJSONErrorHandler(t *testing.T) {
 	tmpDir, _ := ioutil.TempDir("", "test-")
 	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
@@ -38,6 +49,13 @@ func TestJSONErrorHandler(t *testing.T) {
 
 	assert.False(t, handler(rr, req, fs, http.StatusNotFound))
 
+	for _, accept := range []string{"application/jsonp", "*/*", "text/html, application/json;q=0.9"} {
+		req, _ = http.NewRequest(http.MethodGet, "", nil)
+		req.Header.Add("Accept", accept)
+
+		assert.False(t, handler(httptest.NewRecorder(), req, fs, http.StatusNotFound), accept)
+	}
+
 	req, _ = http.NewRequest(http.MethodGet, "", nil)
 	req.Header.Add("Accept", "application/json")
 	rr = httptest.NewRecorder()
@@ -47,6 +65,84 @@ func TestJSONErrorHandler(t *testing.T) {
 	assert.JSONEq(t, `{"code":404,"message":"Not Found"}`, rr.Body.String())
 }
 
+func TestNegotiatedErrorHandler(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	fs, _ := NewFileServer(Settings{FilesRoot: tmpDir})
+	assert.NotNil(t, fs)
+
+	fs.RegisterErrorRenderer("Application/YAML", func(w http.ResponseWriter, _ *http.Request, _ *FileServer, c int) bool {
+		w.WriteHeader(c)
+		_, _ = w.Write([]byte("code: 418"))
+
+		return true
+	})
+
+	handler := NegotiatedErrorHandler()
+
+	for _, tt := range []struct {
+		giveAccept      string
+		wantHandled     bool
+		wantContentType string
+		wantContent     string
+	}{
+		{giveAccept: "", wantHandled: false}, // html renderer, but error file is not defined
+		{giveAccept: "image/png", wantHandled: false},
+		{
+			giveAccept:      "application/json",
+			wantHandled:     true,
+			wantContentType: "application/json; charset=utf-8",
+			wantContent:     `{"code":404,"message":"Not Found"}` + "\n",
+		},
+		{
+			giveAccept:      "application/problem+json, application/json;q=0.5",
+			wantHandled:     true,
+			wantContentType: "application/problem+json; charset=utf-8",
+			wantContent:     `{"type":"about:blank","title":"Not Found","status":404,"instance":"/foo"}` + "\n",
+		},
+		{
+			giveAccept:      "text/xml, application/xml;q=0.9",
+			wantHandled:     true,
+			wantContentType: "application/xml; charset=utf-8",
+			wantContent:     xml.Header + "<error><code>404</code><message>Not Found</message></error>",
+		},
+		{
+			giveAccept:      "text/plain",
+			wantHandled:     true,
+			wantContentType: "text/plain; charset=utf-8",
+			wantContent:     "404 Not Found\n",
+		},
+		{
+			giveAccept:  "application/yaml",
+			wantHandled: true,
+			wantContent: "code: 418",
+		},
+	} {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, "/foo", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("Accept", tt.giveAccept)
+
+		assert.Equal(t, tt.wantHandled, handler(rr, req, fs, http.StatusNotFound), tt.giveAccept)
+		assert.Equal(t, "Accept", rr.Header().Get("Vary"))
+
+		if tt.wantHandled {
+			assert.Equal(t, http.StatusNotFound, rr.Code)
+			assert.Equal(t, tt.wantContentType, rr.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantContent, rr.Body.String())
+		}
+	}
+
+	fs.RegisterErrorRenderer(MediaTypeJSON, func(w http.ResponseWriter, _ *http.Request, _ *FileServer, c int) bool {
+		return false
+	})
+
+	assert.Len(t, fs.ErrorRenderers, len(DefaultErrorRenderers())+1)
+}
+
 func TestStaticHtmlPageErrorHandler(t *testing.T) {
 	tmpDir, _ := ioutil.TempDir("", "test-")
 	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
@@ -73,7 +169,9 @@ func TestStaticHtmlPageErrorHandler(t *testing.T) {
 	_, _ = file.Write([]byte("template: {{ message }} | {{ code }}"))
 	file.Close()
 
-	fs.Settings.ErrorFileName = "error.html"
+	settings := fs.CurrentSettings()
+	settings.ErrorFileName = "error.html"
+	assert.NoError(t, fs.UpdateSettings(settings))
 
 	req, _ = http.NewRequest(http.MethodGet, "", nil)
 	rr = httptest.NewRecorder()
@@ -94,3 +192,94 @@ func TestStaticHtmlPageErrorHandler(t *testing.T) {
 
 	assert.False(t, handler(rr, req, fs, http.StatusNotFound))
 }
+
+func TestStaticHtmlPageErrorHandler_PerStatusCodeFiles(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for name, content := range map[string]string{
+		"error.html": "generic {{ code }}",
+		"404.html":   "not found {{ code }}",
+		"5xx.html":   "server error {{ code }}",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	fs, _ := NewFileServer(Settings{
+		FilesRoot:     tmpDir,
+		ErrorFileName: "error.html",
+		ErrorFileNames: map[string]string{
+			"404": "404.html",
+			"403": "missing.html",
+			"5xx": "5xx.html",
+		},
+		CacheEnabled: true,
+	})
+	assert.NotNil(t, fs)
+	handler := StaticHTMLPageErrorHandler()
+
+	for code, expected := range map[int]string{
+		http.StatusNotFound:            "not found 404",
+		http.StatusForbidden:           "generic 403", // file for the code does not exists
+		http.StatusMethodNotAllowed:    "generic 405",
+		http.StatusInternalServerError: "server error 500",
+		http.StatusBadGateway:          "server error 502",
+	} {
+		for i := 0; i < 2; i++ { // second iteration uses cache
+			req, _ := http.NewRequest(http.MethodGet, "", nil)
+			rr := httptest.NewRecorder()
+
+			assert.True(t, handler(rr, req, fs, code))
+			assert.Equal(t, code, rr.Code)
+			assert.Equal(t, expected, rr.Body.String())
+		}
+	}
+}
+
+func TestStaticHtmlPageErrorHandler_Localized(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for name, content := range map[string]string{
+		"__error__.html":    "default: {{ message }}",
+		"__error__.ru.html": "ru: {{ message }}",
+		"404.html":          "404: {{ message }}",
+		"404.en.html":       "404 en: {{ message }}",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	fs, _ := NewFileServer(Settings{
+		FilesRoot:      tmpDir,
+		ErrorFileName:  "__error__.html",
+		ErrorFileNames: map[string]string{"404": "404.html"},
+		Languages:      []string{"en", "ru", "de"},
+	})
+	assert.NotNil(t, fs)
+
+	fs.Messages = StaticMessageCatalog{"ru": {http.StatusNotFound: "Не найдено", http.StatusForbidden: "Запрещено"}}
+
+	handler := StaticHTMLPageErrorHandler()
+
+	for _, tt := range []struct {
+		giveAcceptLanguage string
+		giveCode           int
+		wantContent        string
+	}{
+		{giveAcceptLanguage: "ru", giveCode: http.StatusForbidden, wantContent: "ru: Запрещено"},
+		{giveAcceptLanguage: "ru", giveCode: http.StatusNotFound, wantContent: "404: Не найдено"},
+		{giveAcceptLanguage: "en", giveCode: http.StatusNotFound, wantContent: "404 en: Not Found"},
+		{giveAcceptLanguage: "de", giveCode: http.StatusForbidden, wantContent: "default: Forbidden"},
+		{giveAcceptLanguage: "", giveCode: http.StatusForbidden, wantContent: "default: Forbidden"},
+	} {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, "/", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("Accept-Language", tt.giveAcceptLanguage)
+
+		assert.True(t, handler(rr, req, fs, tt.giveCode))
+		assert.Equal(t, tt.wantContent, rr.Body.String())
+	}
+}
@@ -1,18 +1,17 @@
 package fileserver
 
 import (
-	"bytes"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/avto-dev/go-simple-fileserver/cache"
+	"golang.org/x/net/webdav"
 )
 
 const (
@@ -28,7 +27,10 @@ type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request, fs *FileServe
 
 // FileServer is a main file server structure (implements `http.Handler` interface).
 type FileServer struct {
-	// Server settings (some of them can be changed in runtime).
+	// Server settings.
+	//
+	// Deprecated: use `CurrentSettings` and `UpdateSettings` (they are thread-safe). Field is read once, when server is
+	// used first time, so `UpdateSettings` is the only way to change settings in runtime.
 	Settings Settings
 
 	// Cacher instance.
@@ -40,8 +42,25 @@ type FileServer struct {
 	// Error handlers stack.
 	ErrorHandlers []ErrorHandlerFunc
 
-	// Allowed HTTP methods map (is used in performance reasons).
-	allowedHTTPMethodsMap map[string]struct{} // fillable in runtime
+	// Error renderers, ordered by server preference (are used by `NegotiatedErrorHandler`).
+	ErrorRenderers []ErrorRenderer
+
+	// Messages catalog for the error messages localization.
+	Messages MessageCatalog // nil, if standard status texts should be used
+
+	// Current config snapshot (settings can be changed in runtime using `UpdateSettings`).
+	cfg   atomic.Value // *config
+	cfgMu sync.Mutex   // serializes snapshots replacing
+
+	// Cache items, that are being loaded (concurrent loads of the same file are deduplicated).
+	cacheLoads loadGroup
+
+	// File system paths, that are being changed by the write requests.
+	writeLocks pathLocks
+
+	// WebDAV locks (are created on first use).
+	davLocks     webdav.LockSystem
+	davLocksOnce sync.Once
 }
 
 // Settings describes file server options.
@@ -49,16 +68,79 @@ type Settings struct {
 	// Directory path, where files for serving is located.
 	FilesRoot string
 
+	// Additional file system layers (ordered), that are looked up before the FilesRoot directory. The first layer,
+	// that contains requested file, wins (eg.: `http.Dir("./tenant")` layer overrides shared theme files in the
+	// FilesRoot). Error page templates are looked up in the same way.
+	Layers []http.FileSystem
+
 	// File name (relative path to the file) that will be used as an index (like <https://bit.ly/356QeFm>).
 	IndexFileName string
 
 	// File name (relative path to the file) that will be used as error page template.
 	ErrorFileName string
 
+	// Error page template file names for the exact HTTP status codes (like `404`) or status classes (like `5xx`). When
+	// the file for the status code is not found - file for the status class is used, then `ErrorFileName`.
+	ErrorFileNames map[string]string
+
+	// Supported languages (eg.: `en`, `ru`), first one is default. Is used for error pages and messages localization
+	// (error page template `__error__.ru.html` is preferred over `__error__.html` for the russian language).
+	Languages []string
+
+	// URL path prefix, that the server is mounted under (like `/static`). It is stripped from the request path and
+	// added to the redirection targets and error page templates (`{{ base_path }}`), so `http.StripPrefix` is not
+	// required. Requests outside the base path are responded with "404 Not Found".
+	BasePath string
+
+	// URL rewriting and redirection rules (are applied in order, before the file lookup).
+	RewriteRules []RewriteRule
+
+	// Redirects file name (relative path in the FilesRoot, like `_redirects`) with additional rewriting rules (see
+	// RewriteRule). File changes are applied in runtime, the file itself is not served.
+	RedirectsFileName string
+
+	// Response headers, that are set for every response, including errors and redirects (use `SecureDefaults()` for
+	// the security headers preset).
+	Headers http.Header
+
+	// Response headers for the URL paths, matched by patterns (are applied in order, after the Headers).
+	HeaderRules []HeaderRule
+
+	// Headers file name (relative path in the FilesRoot, like `_headers`) with additional header rules (are applied
+	// after the HeaderRules). File changes are applied in runtime, the file itself is not served.
+	HeadersFileName string
+
+	// Cross-origin resource sharing options (CORS is disabled by default). Preflight requests are answered even if
+	// `OPTIONS` method is not allowed.
+	CORS CORSSettings
+
+	// Authentication rules (the first matched rule is used, paths without matched rules are public). Rules are checked
+	// for the URL path after rewriting.
+	AuthRules []AuthRule
+
+	// Signed (expiring) URLs options. Signatures are checked for the URL path after rewriting.
+	SignedURLs SignedURLSettings
+
+	// Requests rate and concurrency limits (are disabled by default).
+	RateLimit RateLimitSettings
+
+	// Response bodies bandwidth shaping (global and per-path limits).
+	Bandwidth BandwidthSettings
+
+	// Range requests policy (ranges are allowed for all paths by default).
+	Ranges RangeSettings
+
+	// Write mode options (uploads are disabled by default).
+	Write WriteSettings
+
+	// WebDAV mode options (WebDAV is disabled by default).
+	WebDAV WebDAVSettings
+
 	// Respond "index file" request with redirection to the root (`example.com/index.html` -> `example.com/`).
 	RedirectIndexFileToRoot bool
 
-	// Allowed HTTP methods (eg.: `http.MethodGet`).
+	// Allowed HTTP methods (eg.: `http.MethodGet`). Requests with disallowed methods are responded with `Allow` header,
+	// `OPTIONS` requests (when allowed) are answered automatically.
 	AllowedHTTPMethods []string
 
 	// Enables caching engine.
@@ -67,68 +149,127 @@ type Settings struct {
 	// Maximal data caching lifetime.
 	CacheTTL time.Duration
 
+	// Additional lifetime of the cached data, that is served after the CacheTTL expiration, while the file is reloaded
+	// in background (stale-while-revalidate). Zero disables stale data serving.
+	CacheStaleTTL time.Duration
+
+	// Interval, after which the cached file is revalidated on cache hit: file size, modification time and inode are
+	// compared with the cached ones (cheap `stat` call is used). Unchanged file content is served from the cache
+	// (its lifetime is extended), changed file is reloaded (in background, when CacheStaleTTL is set). Zero disables
+	// revalidation.
+	CacheRevalidateInterval time.Duration
+
 	// Maximum file size (in bytes), that can be placed into the cache.
 	CacheMaxFileSize int64
 
+	// Maximum file size (in bytes), that can be placed into the cache as memory-mapped content (files larger than
+	// CacheMaxFileSize are mapped instead of reading, when the platform supports it). Zero disables files mapping.
+	// Cached files must be replaced atomically (renamed, like write mode does), not truncated in place.
+	CacheMmapMaxFileSize int64
+
 	// Maximum files count, that can be placed into the cache.
 	CacheMaxItems uint32
+
+	// Cache keys prefix (allows to share single cacher between multiple servers).
+	CacheNamespace string
+
+	// Strict validation mode (error page template files must exist).
+	StrictValidation bool
 }
 
 // NewFileServer creates new file server with default settings. Feel free to change default behavior.
 func NewFileServer(s Settings) (*FileServer, error) {
-	if info, err := os.Stat(s.FilesRoot); err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf(`directory "%s" does not exists`, s.FilesRoot)
-		}
-
+	s, err := prepareSettings(s)
+	if err != nil {
 		return nil, err
-	} else if !info.IsDir() {
-		return nil, fmt.Errorf(`"%s" is not directory`, s.FilesRoot)
 	}
 
-	if s.IndexFileName == "" {
-		s.IndexFileName = defaultIndexFileName
-	}
+	fs := newFileServer()
+	fs.Settings = s
 
-	if s.CacheTTL == 0 {
-		s.CacheTTL = defaultCacheTTL
+	if s.CacheEnabled {
+		fs.Cache = cache.NewInMemoryCache(s.CacheTTL / 2) //nolint:gomnd
 	}
 
-	if s.CacheMaxFileSize == 0 {
-		s.CacheMaxFileSize = defaultCacheMaxFileSize
-	}
+	return fs, nil
+}
 
-	if s.CacheMaxItems == 0 {
-		s.CacheMaxItems = defaultCacheMaxItems
+// newFileServer creates file server with default error handlers and without settings.
+func newFileServer() *FileServer {
+	return &FileServer{
+		FallbackErrorContent: defaultFallbackErrorContent,
+		ErrorHandlers: []ErrorHandlerFunc{
+			NegotiatedErrorHandler(),
+		},
+		ErrorRenderers: DefaultErrorRenderers(),
 	}
+}
 
-	if len(s.AllowedHTTPMethods) == 0 {
-		s.AllowedHTTPMethods = append(s.AllowedHTTPMethods, http.MethodGet)
-	}
+// CacheAvailable checks cache availability.
+func (fs *FileServer) CacheAvailable() bool {
+	return fs.cacheAvailable(fs.config())
+}
 
-	fs := &FileServer{
-		Settings:             s,
-		FallbackErrorContent: defaultFallbackErrorContent,
+func (fs *FileServer) cacheAvailable(cfg *config) bool {
+	return cfg.settings.CacheEnabled && fs.Cache != nil
+}
+
+// RegisterErrorRenderer registers error renderer for the media type. Renderer for already registered media type will
+// be replaced, new renderer will be added with the lowest server preference.
+func (fs *FileServer) RegisterErrorRenderer(mediaType string, render ErrorHandlerFunc) {
+	mediaType = strings.ToLower(mediaType)
+
+	for i, renderer := range fs.ErrorRenderers {
+		if renderer.MediaType == mediaType {
+			fs.ErrorRenderers[i].Render = render
+
+			return
+		}
 	}
 
-	if s.CacheEnabled {
-		fs.Cache = cache.NewInMemoryCache(s.CacheTTL / 2) //nolint:gomnd
+	fs.ErrorRenderers = append(fs.ErrorRenderers, ErrorRenderer{MediaType: mediaType, Render: render})
+}
+
+// cachedContent returns reader for the cached item content. Cached content is shared between concurrent requests, so
+// independent reader is created for every call, when it is possible.
+func cachedContent(item *cache.Item) io.ReadSeeker {
+	if ra, ok := item.Content.(interface {
+		io.ReaderAt
+		Size() int64
+	}); ok {
+		return io.NewSectionReader(ra, 0, ra.Size())
 	}
 
-	fs.ErrorHandlers = []ErrorHandlerFunc{
-		JSONErrorHandler(),
-		StaticHTMLPageErrorHandler(),
+	return item.Content
+}
+
+// cleanURLPath removes `.` and `..` elements and repeated slashes from the URL path. Trailing slash is kept (it
+// marks the directory request).
+func cleanURLPath(urlPath string) string {
+	cleaned := path.Clean("/" + urlPath)
+
+	if cleaned != "/" && strings.HasSuffix(urlPath, "/") {
+		cleaned += "/"
 	}
 
-	return fs, nil
+	return cleaned
 }
 
-// CacheAvailable checks cache availability.
-func (fs *FileServer) CacheAvailable() bool {
-	return fs.Settings.CacheEnabled && fs.Cache != nil
+// bodylessResponseWriter discards response body writing (is used for `HEAD` requests).
+type bodylessResponseWriter struct {
+	http.ResponseWriter
 }
 
+// Write discards passed data.
+func (w bodylessResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+
 func (fs *FileServer) handleError(w http.ResponseWriter, r *http.Request, errorCode int) {
+	fs.configFor(r).setResponseHeaders(w, r)
+
+	if r.Method == http.MethodHead {
+		w = bodylessResponseWriter{w}
+	}
+
 	if fs.ErrorHandlers != nil && len(fs.ErrorHandlers) > 0 {
 		for _, handler := range fs.ErrorHandlers {
 			if handler(w, r, fs, errorCode) {
@@ -141,39 +282,58 @@ func (fs *FileServer) handleError(w http.ResponseWriter, r *http.Request, errorC
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(errorCode)
 
-	_, _ = w.Write([]byte(ErrorPageTemplate(fs.FallbackErrorContent).Build(errorCode)))
+	_, _ = w.Write([]byte(ErrorPageTemplate(fs.FallbackErrorContent).Render(fs.errorPageVars(r, errorCode))))
 }
 
-func (fs *FileServer) methodIsAllowed(method string) bool {
-	if fs.allowedHTTPMethodsMap == nil {
-		// burn allowed methods map for fast checking
-		fs.allowedHTTPMethodsMap = make(map[string]struct{})
+// ServeHTTP responds to an HTTP request.
+func (fs *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) { //nolint:funlen,gocognit,gocyclo
+	// settings snapshot is used during the whole request processing
+	cfg := fs.config()
+	r = withConfig(r, cfg)
+
+	if cfg.err != nil { // settings field is invalid
+		fs.handleError(w, r, http.StatusInternalServerError)
 
-		for _, v := range fs.Settings.AllowedHTTPMethods {
-			fs.allowedHTTPMethodsMap[v] = struct{}{}
-		}
+		return
 	}
 
-	_, found := fs.allowedHTTPMethodsMap[method]
+	cfg.setResponseHeaders(w, r)
 
-	return found
-}
+	var ticket *limiterTicket // nil, if limits are not set
 
-// ServeHTTP responds to an HTTP request.
-func (fs *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) { //nolint:funlen,gocognit,gocyclo
-	if !fs.methodIsAllowed(r.Method) {
-		fs.handleError(w, r, http.StatusMethodNotAllowed)
+	if cfg.limiter != nil {
+		var retryAfter time.Duration
+
+		if ticket, retryAfter = cfg.limiter.acquire(r, time.Now()); ticket == nil {
+			fs.tooManyRequests(w, r, retryAfter)
+
+			return
+		}
+
+		defer ticket.release()
+	}
 
+	if cfg.cors != nil && fs.handleCORS(w, r, cfg.cors) {
 		return
 	}
 
-	if fs.Settings.RedirectIndexFileToRoot && len(fs.Settings.IndexFileName) > 0 {
-		// redirect .../index.html to .../
-		if strings.HasSuffix(r.URL.Path, "/"+fs.Settings.IndexFileName) {
-			http.Redirect(w, r, r.URL.Path[0:len(r.URL.Path)-len(fs.Settings.IndexFileName)], http.StatusMovedPermanently)
+	if !cfg.methodIsAllowed(r.Method) {
+		w.Header().Set("Allow", cfg.allowHeader)
+		fs.handleError(w, r, http.StatusMethodNotAllowed)
 
-			return
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		if cfg.dav != nil {
+			w.Header().Set("DAV", cfg.dav.compliance())
+			w.Header().Set("MS-Author-Via", "DAV")
 		}
+
+		w.Header().Set("Allow", cfg.allowHeader)
+		w.WriteHeader(http.StatusNoContent)
+
+		return
 	}
 
 	urlPath := r.URL.Path
@@ -183,57 +343,131 @@ func (fs *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) { //noli
 		urlPath = "/" + r.URL.Path
 	}
 
-	// if directory requested (or server root) - add index file name
-	if len(fs.Settings.IndexFileName) > 0 && urlPath[len(urlPath)-1] == '/' {
-		urlPath += fs.Settings.IndexFileName
-	}
+	if basePath := cfg.settings.BasePath; basePath != "" {
+		switch {
+		case urlPath == basePath: // redirect /base to /base/
+			redirectTo := basePath + "/"
 
-	// prepare target file path
-	filePath := path.Join(fs.Settings.FilesRoot, filepath.FromSlash(path.Clean(urlPath)))
+			if r.URL.RawQuery != "" {
+				redirectTo += "?" + r.URL.RawQuery
+			}
 
-	// look for response in cache
-	if fs.CacheAvailable() {
-		if cached, cacheHit := fs.Cache.Get(filePath); cacheHit {
-			http.ServeContent(w, r, filepath.Base(filePath), cached.ModifiedTime, cached.Content)
+			http.Redirect(w, r, redirectTo, http.StatusMovedPermanently)
+
+			return
+
+		case strings.HasPrefix(urlPath, basePath+"/"):
+			urlPath = urlPath[len(basePath):]
+
+		default: // path outside the base path
+			fs.handleError(w, r, http.StatusNotFound)
 
 			return
 		}
 	}
 
-	// check for file existence
-	if stat, err := os.Stat(filePath); err == nil && stat.Mode().IsRegular() {
-		if file, err := os.Open(filePath); err == nil {
-			defer file.Close()
+	// all path rules (rewrites, access checks, headers, limits) are matched against the cleaned path
+	urlPath = cleanURLPath(urlPath)
 
-			var fileContent io.ReadSeeker
+	if cfg.dav.handles(r.Method) {
+		fs.serveWebDAV(w, r, cfg, urlPath)
 
-			// put file content into cache, if it is possible
-			if fs.CacheAvailable() &&
-				fs.Cache.Count() < fs.Settings.CacheMaxItems &&
-				stat.Size() <= fs.Settings.CacheMaxFileSize {
-				if data, err := ioutil.ReadAll(file); err == nil {
-					fileContent = bytes.NewReader(data)
+		return
+	}
 
-					fs.Cache.Set(filePath, fs.Settings.CacheTTL, &cache.Item{
-						ModifiedTime: stat.ModTime(),
-						Content:      fileContent,
-					})
-				}
-			}
+	if cfg.writer.handles(r.Method) {
+		fs.serveWrite(w, r, cfg, urlPath)
 
-			if fileContent == nil {
-				fileContent = file
-			}
+		return
+	}
 
-			http.ServeContent(w, r, filepath.Base(filePath), stat.ModTime(), fileContent)
+	if cfg.settings.RedirectIndexFileToRoot && len(cfg.settings.IndexFileName) > 0 {
+		// redirect .../index.html to .../ (requested path is checked, not the rewritten one)
+		if strings.HasSuffix(urlPath, "/"+cfg.settings.IndexFileName) {
+			http.Redirect(w, r,
+				cfg.settings.BasePath+urlPath[0:len(urlPath)-len(cfg.settings.IndexFileName)],
+				http.StatusMovedPermanently,
+			)
 
 			return
 		}
+	}
 
+	urlPath, rawQuery, location, status, err := cfg.rewrite(urlPath, r.URL.RawQuery)
+	if err != nil {
 		fs.handleError(w, r, http.StatusInternalServerError)
 
 		return
 	}
 
-	fs.handleError(w, r, http.StatusNotFound)
+	if location != "" {
+		http.Redirect(w, r, location, status)
+
+		return
+	}
+
+	if rawQuery != r.URL.RawQuery { // internal rewrite target query is applied
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = rawQuery
+	}
+
+	// if directory requested (or server root) - add index file name
+	if len(cfg.settings.IndexFileName) > 0 && urlPath[len(urlPath)-1] == '/' {
+		urlPath += cfg.settings.IndexFileName
+	}
+
+	if cfg.isHiddenPath(urlPath) {
+		fs.handleError(w, r, http.StatusNotFound)
+
+		return
+	}
+
+	r, authorized := fs.checkAccess(w, r, cfg, urlPath)
+	if !authorized {
+		return
+	}
+
+	resolved, err := fs.resolveFile(cfg, urlPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fs.handleError(w, r, http.StatusNotFound)
+		} else {
+			fs.handleError(w, r, http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	defer resolved.Close()
+
+	// serve response from cache
+	if resolved.cached != nil {
+		content := cachedContent(resolved.cached)
+		w, r = cfg.applyRangePolicy(cfg.throttle(w, r, urlPath), r, urlPath, content)
+
+		http.ServeContent(w, r, path.Base(resolved.name), resolved.cached.ModifiedTime, content)
+
+		return
+	}
+
+	if ticket != nil && !ticket.acquireLargeStream(resolved.info.Size()) {
+		fs.tooManyRequests(w, r, inFlightRetryAfter)
+
+		return
+	}
+
+	// the file is passed as is (`*os.File` for the local directories), so the `sendfile` (zero-copy) is used by the
+	// `net/http` server, when response body is not transformed (throttled or split into multiple ranges)
+	var fileContent io.ReadSeeker = resolved.file
+
+	// put file content into cache, if it is possible
+	if fs.cacheAvailable(cfg) && fs.Cache.Count() < cfg.settings.CacheMaxItems {
+		if item := fs.loadCacheItem(cfg, resolved); item != nil {
+			fileContent = cachedContent(item)
+		}
+	}
+
+	w, r = cfg.applyRangePolicy(cfg.throttle(w, r, urlPath), r, urlPath, fileContent)
+
+	http.ServeContent(w, r, path.Base(resolved.name), resolved.info.ModTime(), fileContent)
 }
@@ -1,12 +1,14 @@
 package fileserver
 
 import (
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -106,6 +108,165 @@ func TestFileServer_ServeHTTP(t *testing.T) {
 			wantResponseHTTPCode:   http.StatusMethodNotAllowed,
 			wantResponseSubstrings: []string{"Method Not Allowed"},
 		},
+		{
+			name: "disallowed HTTP method response contains allowed methods",
+			giveSettings: Settings{
+				AllowedHTTPMethods: []string{http.MethodGet, http.MethodHead},
+			},
+			giveRequestMethod:    http.MethodPost,
+			giveRequestURI:       "/test",
+			wantResponseHTTPCode: http.StatusMethodNotAllowed,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "GET, HEAD", rr.Header().Get("Allow"))
+			},
+		},
+		{
+			name: "OPTIONS request is answered automatically",
+			giveSettings: Settings{
+				AllowedHTTPMethods: []string{http.MethodGet, http.MethodHead, http.MethodOptions},
+			},
+			giveRequestMethod:    http.MethodOptions,
+			giveRequestURI:       "/test",
+			wantResponseHTTPCode: http.StatusNoContent,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "GET, HEAD, OPTIONS", rr.Header().Get("Allow"))
+				assert.Empty(t, rr.Body.String())
+			},
+		},
+		{
+			name: "OPTIONS request when it is disallowed",
+			giveSettings: Settings{
+				AllowedHTTPMethods: []string{http.MethodGet},
+			},
+			giveRequestMethod:    http.MethodOptions,
+			giveRequestURI:       "/test",
+			wantResponseHTTPCode: http.StatusMethodNotAllowed,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "GET", rr.Header().Get("Allow"))
+			},
+		},
+		{
+			name: "HEAD request for existing file",
+			giveSettings: Settings{
+				AllowedHTTPMethods: []string{http.MethodGet, http.MethodHead},
+			},
+			giveRequestMethod: http.MethodHead,
+			giveRequestURI:    "/test",
+			giveFiles: map[string][]byte{
+				"test": []byte("test content"),
+			},
+			wantResponseHTTPCode: http.StatusOK,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "12", rr.Header().Get("Content-Length"))
+				assert.Empty(t, rr.Body.String())
+			},
+		},
+		{
+			name: "HEAD request for missing file",
+			giveSettings: Settings{
+				AllowedHTTPMethods: []string{http.MethodGet, http.MethodHead},
+			},
+			giveRequestMethod:    http.MethodHead,
+			giveRequestURI:       "/test",
+			giveRequestHeaders:   map[string]string{"accept": "application/json"},
+			wantResponseHTTPCode: http.StatusNotFound,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "application/json; charset=utf-8", rr.Header().Get("Content-Type"))
+				assert.Empty(t, rr.Body.String())
+			},
+		},
+		{
+			name: "HEAD request with disallowed method",
+			giveSettings: Settings{
+				AllowedHTTPMethods: []string{http.MethodGet},
+			},
+			giveRequestMethod:    http.MethodHead,
+			giveRequestURI:       "/test",
+			wantResponseHTTPCode: http.StatusMethodNotAllowed,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "GET", rr.Header().Get("Allow"))
+				assert.Empty(t, rr.Body.String())
+			},
+		},
+		{
+			name: "HEAD request with custom error handler fallback",
+			giveSettings: Settings{
+				AllowedHTTPMethods: []string{http.MethodHead},
+			},
+			beforeServing: func(fs *FileServer) {
+				fs.ErrorHandlers = nil
+			},
+			giveRequestMethod:    http.MethodHead,
+			giveRequestURI:       "/test",
+			wantResponseHTTPCode: http.StatusNotFound,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Empty(t, rr.Body.String())
+			},
+		},
+		{
+			name:           "file serving under base path",
+			giveSettings:   Settings{BasePath: "/static/"},
+			giveRequestURI: "/static/test",
+			giveFiles: map[string][]byte{
+				"test": []byte("test content"),
+			},
+			wantResponseHTTPCode: http.StatusOK,
+			wantResponseContent:  "test content",
+		},
+		{
+			name:           "index file serving under base path",
+			giveSettings:   Settings{BasePath: "/static"},
+			giveRequestURI: "/static/",
+			giveFiles: map[string][]byte{
+				"index.html": []byte("index content"),
+			},
+			wantResponseHTTPCode: http.StatusOK,
+			wantResponseContent:  "index content",
+		},
+		{
+			name:           "request outside base path",
+			giveSettings:   Settings{BasePath: "/static"},
+			giveRequestURI: "/staticfoo/test",
+			giveFiles: map[string][]byte{
+				"test": []byte("test content"),
+			},
+			wantResponseHTTPCode: http.StatusNotFound,
+		},
+		{
+			name:                 "base path root redirection",
+			giveSettings:         Settings{BasePath: "/static"},
+			giveRequestURI:       "/static?foo=bar",
+			wantResponseHTTPCode: http.StatusMovedPermanently,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "/static/?foo=bar", rr.Header().Get("Location"))
+			},
+		},
+		{
+			name: "redirect from ./foo/{indexFileName} to ./foo/ under base path",
+			giveSettings: Settings{
+				BasePath:                "/static",
+				IndexFileName:           "idx.html",
+				RedirectIndexFileToRoot: true,
+			},
+			giveRequestURI:       "/static/foo/idx.html",
+			wantResponseHTTPCode: http.StatusMovedPermanently,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "/static/foo/", rr.Header().Get("Location"))
+			},
+		},
+		{
+			name: "error page template with base path",
+			giveSettings: Settings{
+				BasePath:      "/static",
+				ErrorFileName: "error.html",
+			},
+			giveRequestURI: "/static/foo",
+			giveFiles: map[string][]byte{
+				"error.html": []byte(`<link href="{{ base_path }}/style.css">{{ code }}`),
+			},
+			wantResponseHTTPCode: http.StatusNotFound,
+			wantResponseContent:  `<link href="/static/style.css">404`,
+		},
 		{
 			name:                 "directory above (./../) requested",
 			giveRequestURI:       "/../../../../etc/passwd",
@@ -224,6 +385,24 @@ func TestFileServer_ServeHTTP(t *testing.T) {
 				assert.JSONEq(t, `{"code":404,"message":"Not Found"}`, rr.Body.String())
 			},
 		},
+		{
+			name:                 "error in problem json format when it preferred",
+			giveRequestURI:       "/foo",
+			giveRequestHeaders:   map[string]string{"accept": "application/json;q=0.5, application/problem+json"},
+			wantResponseHTTPCode: http.StatusNotFound,
+			resultCheckingFn: func(t *testing.T, rr *httptest.ResponseRecorder) {
+				assert.Equal(t, "application/problem+json; charset=utf-8", rr.Header().Get("Content-Type"))
+				assert.JSONEq(t, `{"type":"about:blank","title":"Not Found","status":404,"instance":"/foo"}`,
+					rr.Body.String())
+			},
+		},
+		{
+			name:                   "html error when jsonp requested",
+			giveRequestURI:         "/foo",
+			giveRequestHeaders:     map[string]string{"accept": "application/jsonp"},
+			wantResponseHTTPCode:   http.StatusNotFound,
+			wantResponseSubstrings: []string{"<html>", "Error 404"},
+		},
 	}
 
 	for _, tt := range cases {
@@ -339,3 +518,64 @@ func TestFileServer_CacheUsage(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, rr.Code)
 	assert.Contains(t, rr.Body.String(), "Not Found") // cache expired and now file not foud
 }
+
+// readerFromRecorder records the sources, passed to the `ReadFrom` method (like `net/http` server does for the
+// `sendfile` usage).
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	sources []io.Reader
+}
+
+func (w *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	w.sources = append(w.sources, src)
+
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+func TestFileServer_ServeHTTP_ZeroCopy(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	content := strings.Repeat("large file content ", 64)
+
+	for _, name := range []string{"large.txt", "video.txt"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:        tmpDir,
+		CacheEnabled:     true,
+		CacheMaxFileSize: 16,
+		Ranges:           RangeSettings{DisabledPaths: []string{"/video.txt"}},
+	})
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		uri, ranges string
+		want        string
+	}{
+		{uri: "/large.txt", want: content},
+		{uri: "/large.txt", ranges: "bytes=19-36", want: content[19:37]},
+		{uri: "/video.txt", ranges: "bytes=0-1", want: content},
+	} {
+		var (
+			req = httptest.NewRequest(http.MethodGet, tc.uri, nil)
+			rr  = &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		)
+
+		if tc.ranges != "" {
+			req.Header.Set("Range", tc.ranges)
+		}
+
+		fs.ServeHTTP(rr, req)
+		assert.Equal(t, tc.want, rr.Body.String())
+
+		if assert.Len(t, rr.sources, 1, tc.uri) {
+			limited, ok := rr.sources[0].(*io.LimitedReader)
+
+			if assert.True(t, ok) {
+				assert.IsType(t, &os.File{}, limited.R, "file is passed to the writer as is")
+			}
+		}
+	}
+}
@@ -3,6 +3,10 @@ module github.com/avto-dev/go-simple-fileserver
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -0,0 +1,222 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// HeaderRule describes additional response headers for the URL paths, that match the pattern.
+type HeaderRule struct {
+	// Path pattern (relative to the `Settings.BasePath`): regular expression (when it starts with `^`) or glob (`*`
+	// matches any characters sequence, including `/`).
+	Path string
+
+	// Response headers, that are set for the matched paths.
+	Headers http.Header
+}
+
+// headerRule is a compiled HeaderRule.
+type headerRule struct {
+	path    *regexp.Regexp
+	headers http.Header
+}
+
+// SecureDefaults returns security related response headers preset (can be used as `Settings.Headers` value or
+// extended with additional headers).
+func SecureDefaults() http.Header {
+	return http.Header{
+		"Strict-Transport-Security": {"max-age=31536000; includeSubDomains"},
+		"X-Content-Type-Options":    {"nosniff"},
+		"X-Frame-Options":           {"DENY"},
+		"Content-Security-Policy":   {"default-src 'self'"},
+		"Referrer-Policy":           {"strict-origin-when-cross-origin"},
+		"Permissions-Policy":        {"camera=(), microphone=(), geolocation=()"},
+	}
+}
+
+// canonicalHeaders returns headers copy with canonical header names. Error is returned for the wrong header name.
+func canonicalHeaders(h http.Header) (http.Header, error) {
+	result := make(http.Header, len(h))
+
+	for name, values := range h {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf(`wrong header name "%s"`, name)
+		}
+
+		for _, value := range values {
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf(`wrong header "%s" value`, name)
+			}
+		}
+
+		key := http.CanonicalHeaderKey(name)
+		result[key] = append(result[key], values...)
+	}
+
+	return result, nil
+}
+
+func compileHeaderRule(rule HeaderRule) (*headerRule, error) {
+	pattern, err := compilePathPattern(rule.Path)
+	if err != nil {
+		return nil, fmt.Errorf("wrong pattern: %w", err)
+	}
+
+	headers, err := canonicalHeaders(rule.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &headerRule{path: pattern, headers: headers}, nil
+}
+
+// compileHeaderRules compiles (already validated) rules. Wrong rules are skipped.
+func compileHeaderRules(rules []HeaderRule) []*headerRule {
+	result := make([]*headerRule, 0, len(rules))
+
+	for _, rule := range rules {
+		if compiled, err := compileHeaderRule(rule); err == nil {
+			result = append(result, compiled)
+		}
+	}
+
+	return result
+}
+
+// parseHeadersFile parses headers file content. Every path pattern (non-indented line) is followed by indented
+// `Name: value` lines (comments are started with `#`):
+//
+//	/*
+//	  X-Frame-Options: DENY
+//	/assets/*
+//	  Cache-Control: public, max-age=31536000
+func parseHeadersFile(data []byte) ([]*headerRule, error) {
+	var (
+		rules   = make([]HeaderRule, 0)
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+	)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' { // path pattern
+			rules = append(rules, HeaderRule{Path: trimmed, Headers: make(http.Header)})
+
+			continue
+		}
+
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("line %d: path pattern is expected", lineNumber)
+		}
+
+		i := strings.IndexByte(trimmed, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: wrong header format", lineNumber)
+		}
+
+		rules[len(rules)-1].Headers.Add(strings.TrimSpace(trimmed[:i]), strings.TrimSpace(trimmed[i+1:]))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	compiled := make([]*headerRule, len(rules))
+
+	for i, rule := range rules {
+		c, err := compileHeaderRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule for %s: %w", rule.Path, err)
+		}
+
+		compiled[i] = c
+	}
+
+	return compiled, nil
+}
+
+// relativePath returns cleaned (see cleanURLPath) URL path relative to the `Settings.BasePath`. False is returned for
+// the paths outside the base path.
+func (cfg *config) relativePath(urlPath string) (string, bool) {
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+
+	if basePath := cfg.settings.BasePath; basePath != "" {
+		if !strings.HasPrefix(urlPath, basePath+"/") {
+			return "", false
+		}
+
+		urlPath = urlPath[len(basePath):]
+	}
+
+	return cleanURLPath(urlPath), true
+}
+
+// setResponseHeaders sets configured response headers (global headers, then matched path rules from the settings and
+// headers file) for the request. Headers are replaced, so it is safe to call it several times.
+func (cfg *config) setResponseHeaders(w http.ResponseWriter, r *http.Request) {
+	for name, values := range cfg.headers {
+		setHeader(w.Header(), name, values)
+	}
+
+	if len(cfg.headerRules) == 0 && cfg.headersFile == nil {
+		return
+	}
+
+	urlPath, ok := cfg.relativePath(r.URL.Path)
+	if !ok {
+		return
+	}
+
+	rules := cfg.headerRules
+
+	if cfg.headersFile != nil {
+		if fileRules, _ := cfg.headersFile.Value().([]*headerRule); len(fileRules) > 0 {
+			rules = append(append(make([]*headerRule, 0, len(rules)+len(fileRules)), rules...), fileRules...)
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.path.MatchString(urlPath) {
+			for name, values := range rule.headers {
+				setHeader(w.Header(), name, values)
+			}
+		}
+	}
+}
+
+// setHeader replaces response header values (name must be canonical). `Vary` header values are merged instead, so
+// values, that are added during the request processing (like `Vary: Origin`), are kept.
+func setHeader(h http.Header, name string, values []string) {
+	if name != "Vary" {
+		h[name] = append([]string(nil), values...)
+
+		return
+	}
+
+	for _, value := range values {
+		found := false
+
+		for _, existing := range h[name] {
+			if strings.EqualFold(existing, value) {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			h.Add(name, value)
+		}
+	}
+}
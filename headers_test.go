@@ -0,0 +1,141 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeadersFile(t *testing.T) {
+	rules, err := parseHeadersFile([]byte("# comment\n/*\n  x-frame-options: DENY\n\n/assets/*\n\tCache-Control: public\n" +
+		"  Link: </a.css>; rel=preload\n  Link: </b.js>; rel=preload\n"))
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	assert.Equal(t, http.Header{"X-Frame-Options": {"DENY"}}, rules[0].headers)
+	assert.Equal(t, []string{"</a.css>; rel=preload", "</b.js>; rel=preload"}, rules[1].headers["Link"])
+	assert.True(t, rules[1].path.MatchString("/assets/js/app.js"))
+
+	for _, content := range []string{
+		"  X-Foo: bar",
+		"/*\n  X-Foo",
+		"/*\n  : bar",
+		"^/(\n  X-Foo: bar",
+	} {
+		_, err := parseHeadersFile([]byte(content))
+		assert.Error(t, err, content)
+	}
+}
+
+func TestFileServer_ServeHTTP_Headers(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for name, content := range map[string]string{
+		"index.html": "index",
+		"app.js":     "app",
+		"_headers":   "/*.js\n  Cache-Control: public, max-age=60\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	headers := SecureDefaults()
+	headers.Set("X-Frame-Options", "SAMEORIGIN")
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:          tmpDir,
+		BasePath:           "/site",
+		AllowedHTTPMethods: []string{http.MethodGet, http.MethodHead},
+		Headers:            headers,
+		HeaderRules: []HeaderRule{
+			{Path: "/*", Headers: http.Header{"x-served-by": {"fileserver"}}},
+			{Path: "/*.js", Headers: http.Header{"Cache-Control": {"no-cache"}}},
+			{Path: "/app.js", Headers: http.Header{"X-Script": {"app"}}},
+		},
+		HeadersFileName: "_headers",
+		RewriteRules:    []RewriteRule{{From: "/old", To: "/", Status: http.StatusFound}},
+		CacheEnabled:    true,
+	})
+	assert.NoError(t, err)
+
+	serve := func(method, uri string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(method, uri, nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for _, tt := range []struct {
+		method, uri string
+		wantCode    int
+	}{
+		{method: http.MethodGet, uri: "/site/", wantCode: http.StatusOK},
+		{method: http.MethodGet, uri: "/site/", wantCode: http.StatusOK}, // from cache
+		{method: http.MethodHead, uri: "/site/missing", wantCode: http.StatusNotFound},
+		{method: http.MethodPost, uri: "/site/", wantCode: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, uri: "/site", wantCode: http.StatusMovedPermanently},
+		{method: http.MethodGet, uri: "/site/old", wantCode: http.StatusFound},
+		{method: http.MethodGet, uri: "/site/_headers", wantCode: http.StatusNotFound},
+	} {
+		rr := serve(tt.method, tt.uri)
+
+		assert.Equal(t, tt.wantCode, rr.Code, tt.uri)
+		assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"), tt.uri)
+		assert.Equal(t, "SAMEORIGIN", rr.Header().Get("X-Frame-Options"), tt.uri)
+		assert.NotEmpty(t, rr.Header().Get("Strict-Transport-Security"), tt.uri)
+		assert.NotEmpty(t, rr.Header().Get("Content-Security-Policy"), tt.uri)
+	}
+
+	// path rules
+	for _, uri := range []string{"/site/app.js", "/site/./app.js", "/site/js/../app.js"} {
+		rr := serve(http.MethodGet, uri)
+		assert.Equal(t, "fileserver", rr.Header().Get("X-Served-By"), uri)
+		assert.Equal(t, "app", rr.Header().Get("X-Script"), uri)
+		assert.Equal(t, []string{"public, max-age=60"}, rr.Header()["Cache-Control"], uri) // headers file rule wins
+	}
+
+	rr := serve(http.MethodGet, "/outside")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	assert.Empty(t, rr.Header().Get("X-Served-By"))
+
+	// headers file changes are applied in runtime
+	fs.config().headersFile.checkInterval = 0
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "_headers"), []byte("/app.js\n  X-Foo: bar\n"), 0600))
+	assert.NoError(t, os.Chtimes(filepath.Join(tmpDir, "_headers"), time.Now(), time.Now().Add(time.Minute)))
+
+	rr = serve(http.MethodGet, "/site/app.js")
+	assert.Equal(t, "bar", rr.Header().Get("X-Foo"))
+	assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+}
+
+func TestSettings_Validate_Headers(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{
+		FilesRoot:       tmpDir,
+		Headers:         http.Header{"X Foo": {"bar"}},
+		HeadersFileName: "/_headers",
+		HeaderRules: []HeaderRule{
+			{Path: "/*", Headers: http.Header{"X-Foo": {"bar"}}},
+			{Path: "^/(", Headers: http.Header{"X-Foo": {"bar"}}},
+			{Path: "/*", Headers: http.Header{"X-Foo": {"bar\r\nX-Injected: 1"}}},
+		},
+	}.Validate()
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Headers", "HeaderRules[1]", "HeaderRules[2]", "HeadersFileName"},
+		err.(ValidationErrors).Fields())
+}
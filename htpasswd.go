@@ -0,0 +1,199 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"  //nolint:gosec // is required by the apr1 hashing algorithm
+	"crypto/sha1" //nolint:gosec // is required by the htpasswd SHA hashing algorithm
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuth is an Authenticator, that uses HTTP Basic authentication with credentials from the htpasswd file
+// (bcrypt, SHA and apr1 password hashes are supported). File changes are applied in runtime.
+type BasicAuth struct {
+	// Authentication realm (is sent in `WWW-Authenticate` header).
+	Realm string
+
+	file *watchedFile
+}
+
+// NewBasicAuth creates Basic authenticator for the htpasswd file.
+func NewBasicAuth(realm, htpasswdFile string) (*BasicAuth, error) {
+	a := &BasicAuth{
+		Realm: realm,
+		file: newWatchedFile(htpasswdFile, func(data []byte) (interface{}, error) {
+			return parseHtpasswd(data)
+		}),
+	}
+
+	if a.file.Value() == nil {
+		return nil, fmt.Errorf(`htpasswd file "%s" cannot be loaded`, htpasswdFile)
+	}
+
+	return a, nil
+}
+
+// Authenticate implements Authenticator interface.
+func (a *BasicAuth) Authenticate(r *http.Request) (string, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", ErrNoCredentials
+	}
+
+	users, _ := a.file.Value().(map[string]string)
+
+	hash, found := users[user]
+	if !found {
+		return "", ErrInvalidCredentials
+	}
+
+	if !checkPasswordHash(hash, password) {
+		return "", ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// Challenge implements Authenticator interface.
+func (a *BasicAuth) Challenge() string {
+	return fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, strings.ReplaceAll(a.Realm, `"`, `\"`))
+}
+
+// parseHtpasswd parses htpasswd file content (`user:hash` lines, comments are started with `#`).
+func parseHtpasswd(data []byte) (map[string]string, error) {
+	var (
+		users   = make(map[string]string)
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+	)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i <= 0 || i == len(line)-1 {
+			return nil, fmt.Errorf("line %d: wrong format", lineNumber)
+		}
+
+		users[line[:i]] = line[i+1:]
+	}
+
+	return users, scanner.Err()
+}
+
+// checkPasswordHash compares password with htpasswd hash. Plain text passwords are not supported.
+func checkPasswordHash(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password)) //nolint:gosec
+
+		return subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+
+	case strings.HasPrefix(hash, apr1Magic):
+		salt := strings.SplitN(hash[len(apr1Magic):], "$", 2)[0] //nolint:gomnd
+
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1Hash(password, salt))) == 1
+	}
+
+	return false
+}
+
+const (
+	apr1Magic    = "$apr1$"
+	apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// apr1Hash calculates Apache MD5 (apr1) password hash.
+func apr1Hash(password, salt string) string { //nolint:funlen
+	const maxSaltLength = 8
+
+	if len(salt) > maxSaltLength {
+		salt = salt[:maxSaltLength]
+	}
+
+	pw, sl := []byte(password), []byte(salt)
+
+	ctx := md5.New() //nolint:gosec
+	ctx.Write(pw)
+	ctx.Write([]byte(apr1Magic))
+	ctx.Write(sl)
+
+	alt := md5.New() //nolint:gosec
+	alt.Write(pw)
+	alt.Write(sl)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	for i := len(pw); i > 0; i -= md5.Size {
+		if i > md5.Size {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[:i])
+		}
+	}
+
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New() //nolint:gosec
+
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+
+		if i%3 != 0 {
+			round.Write(sl)
+		}
+
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+
+		final = round.Sum(nil)
+	}
+
+	out := make([]byte, 0, 22) //nolint:gomnd
+
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			out = append(out, apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+
+	for _, idx := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[idx[0]])<<16|uint(final[idx[1]])<<8|uint(final[idx[2]]), 4) //nolint:gomnd
+	}
+
+	encode(uint(final[11]), 2) //nolint:gomnd
+
+	return apr1Magic + salt + "$" + string(out)
+}
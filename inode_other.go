@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package fileserver
+
+import "os"
+
+// fileInode is a stub for the platforms without inode numbers (zero is returned).
+func fileInode(os.FileInfo) uint64 { return 0 }
@@ -0,0 +1,166 @@
+package fileserver
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/avto-dev/go-simple-fileserver/cache"
+)
+
+// lastLayerID is the last assigned identifier of the layer, that is not a local directory.
+var lastLayerID uint64 //nolint:gochecknoglobals
+
+// layer is a files source (`Settings.Layers` item or `Settings.FilesRoot` directory).
+type layer struct {
+	fs  http.FileSystem
+	dir string // directory path, if layer is a local directory
+	id  string // unique layer identifier (is used for the cache keys building)
+}
+
+// newLayers creates files sources list (ordered by priority) for the settings. Layers, that are not local directories,
+// get new identifiers (see reuseLayerIDs), so cached content of the replaced layer is never served by another one.
+func newLayers(s Settings) []*layer {
+	layers := make([]*layer, 0, len(s.Layers)+1)
+
+	for _, l := range s.Layers {
+		if dir, ok := l.(http.Dir); ok {
+			layers = append(layers, &layer{fs: dir, dir: string(dir)})
+		} else {
+			id := "layer#" + strconv.FormatUint(atomic.AddUint64(&lastLayerID, 1), 10) + ":"
+			layers = append(layers, &layer{fs: l, id: id})
+		}
+	}
+
+	return append(layers, &layer{fs: http.Dir(s.FilesRoot), dir: s.FilesRoot})
+}
+
+// reuseLayerIDs assigns identifiers of the previous snapshot layers to the same (equal) layers of the new snapshot, so
+// their cached content is used after the settings updating.
+func reuseLayerIDs(layers, prev []*layer) {
+	for _, l := range layers {
+		if l.dir != "" || l.fs == nil || !isComparable(reflect.ValueOf(l.fs)) {
+			continue
+		}
+
+		for _, p := range prev {
+			if p.dir == "" && p.fs != nil && isComparable(reflect.ValueOf(p.fs)) && p.fs == l.fs {
+				l.id = p.id
+
+				break
+			}
+		}
+	}
+}
+
+// isComparable checks that value can be compared using `==` without panic (type comparability is not enough, when the
+// value contains interfaces with non-comparable dynamic values).
+func isComparable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil() || isComparable(v.Elem())
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isComparable(v.Field(i)) {
+				return false
+			}
+		}
+
+		return true
+
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isComparable(v.Index(i)) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return v.Type().Comparable()
+}
+
+// key returns unique (across all layers) file key.
+func (l *layer) key(name string) string {
+	if l.dir != "" {
+		return path.Join(l.dir, name)
+	}
+
+	return l.id + name
+}
+
+// stat returns file info without the file reading (the file is opened, when layer is not a local directory).
+func (l *layer) stat(name string) (os.FileInfo, error) {
+	if l.dir != "" {
+		return os.Stat(filepath.Join(l.dir, filepath.FromSlash(name)))
+	}
+
+	f, err := l.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// resolvedFile is a regular file, found in one of the layers.
+type resolvedFile struct {
+	layer  *layer
+	name   string      // file name (with leading `/`)
+	cached *cache.Item // nil, if file content was not found in cache
+	file   http.File   // nil, if cached content is used
+	info   os.FileInfo // nil, if cached content is used
+}
+
+// Close closes opened file.
+func (f *resolvedFile) Close() error {
+	if f.file != nil {
+		return f.file.Close()
+	}
+
+	return nil
+}
+
+// resolveFile looks for the regular file in the layers (first layer, that contains the file, wins). Cache is checked
+// (and revalidated, when it is required) for every layer before the layer file system accessing. Returned error
+// satisfies `os.IsNotExist`, when file is not found in any layer.
+func (fs *FileServer) resolveFile(cfg *config, name string) (*resolvedFile, error) {
+	name = path.Clean("/" + name)
+
+	for _, l := range cfg.layers {
+		if fs.cacheAvailable(cfg) {
+			cached, cacheHit := fs.Cache.Get(cfg.cacheKey(l.key(name)))
+			if cacheHit && fs.revalidateCacheItem(cfg, l, name, cached) {
+				return &resolvedFile{layer: l, name: name, cached: cached}, nil
+			}
+		}
+
+		file, err := l.fs.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			_ = file.Close()
+
+			continue
+		}
+
+		return &resolvedFile{layer: l, name: name, file: file, info: info}, nil
+	}
+
+	return nil, os.ErrNotExist
+}
@@ -0,0 +1,154 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// customFileSystem hides underlying http.Dir type (is used as "another file system backend").
+type customFileSystem struct{ http.FileSystem }
+
+type funcFileSystem func(name string) (http.File, error) // is not comparable
+
+func (f funcFileSystem) Open(name string) (http.File, error) { return f(name) }
+
+func TestFileServer_ServeHTTP_Layers(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for _, dir := range []string{"base", "tenant", "custom"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, dir), 0700))
+	}
+
+	for name, content := range map[string]string{
+		"base/index.html":    "base index",
+		"base/style.css":     "base style",
+		"base/logo.svg":      "base logo",
+		"base/error.html":    "base error {{ code }}",
+		"tenant/style.css":   "tenant style",
+		"tenant/error.html":  "tenant error {{ code }}",
+		"custom/logo.svg":    "custom logo",
+		"custom/style.css":   "custom style",
+		"custom/index.html/": "",
+	} {
+		if name[len(name)-1] == '/' {
+			assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, name), 0700)) // directory shadows nothing
+
+			continue
+		}
+
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:     filepath.Join(tmpDir, "base"),
+		ErrorFileName: "error.html",
+		Layers: []http.FileSystem{
+			http.Dir(filepath.Join(tmpDir, "tenant")),
+			customFileSystem{http.Dir(filepath.Join(tmpDir, "custom"))},
+		},
+		CacheEnabled: true,
+	})
+	assert.NoError(t, err)
+
+	serve := func(uri string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, uri, nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for i := 0; i < 2; i++ { // second iteration uses cache
+		for uri, expected := range map[string]string{
+			"/":          "base index",
+			"/style.css": "tenant style",
+			"/logo.svg":  "custom logo",
+			"/missing":   "tenant error 404",
+		} {
+			assert.Equal(t, expected, serve(uri).Body.String(), uri)
+		}
+	}
+
+	// cache keys contain resolved layer
+	_, cacheHit := fs.Cache.Get(filepath.Join(tmpDir, "tenant", "style.css"))
+	assert.True(t, cacheHit)
+	_, cacheHit = fs.Cache.Get(fs.config().layers[1].key("/logo.svg"))
+	assert.True(t, cacheHit)
+
+	// file, added into the upper layer, overrides cached file from the lower layer
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "tenant", "logo.svg"), []byte("tenant logo"), 0600))
+	assert.Equal(t, "tenant logo", serve("/logo.svg").Body.String())
+
+	// cached content of the replaced layer is not used
+	settings := fs.CurrentSettings()
+	settings.Layers = []http.FileSystem{customFileSystem{http.Dir(filepath.Join(tmpDir, "tenant"))}}
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	assert.Equal(t, "tenant logo", serve("/logo.svg").Body.String())
+	assert.Equal(t, "tenant style", serve("/style.css").Body.String())
+
+	settings.Layers = []http.FileSystem{customFileSystem{http.Dir(filepath.Join(tmpDir, "custom"))}}
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	assert.Equal(t, "custom logo", serve("/logo.svg").Body.String())
+	assert.Equal(t, "custom style", serve("/style.css").Body.String())
+
+	// cached content of the same layer is used
+	key := fs.config().layers[0].key("/logo.svg")
+
+	settings.CacheTTL *= 2
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	assert.Equal(t, key, fs.config().layers[0].key("/logo.svg"))
+}
+
+func TestFileServer_UpdateSettings_NotComparableLayers(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "test"), []byte("test content"), 0600))
+
+	settings := Settings{
+		FilesRoot: tmpDir,
+		Layers: []http.FileSystem{
+			customFileSystem{funcFileSystem(http.Dir(tmpDir).Open)},
+			funcFileSystem(http.Dir(tmpDir).Open),
+		},
+	}
+
+	fs, err := NewFileServer(settings)
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, "/test", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		assert.NotPanics(t, func() {
+			assert.NoError(t, fs.UpdateSettings(settings))
+			fs.ServeHTTP(rr, req)
+		})
+
+		assert.Equal(t, "test content", rr.Body.String())
+	}
+}
+
+func TestSettings_Validate_Layers(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{FilesRoot: tmpDir, Layers: []http.FileSystem{http.Dir(tmpDir), nil}}.Validate()
+
+	assert.EqualError(t, err, "wrong settings: Layers[1]: must not be nil")
+}
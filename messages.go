@@ -0,0 +1,53 @@
+package fileserver
+
+import "net/http"
+
+// MessageCatalog provides (localized) messages for the HTTP status codes.
+type MessageCatalog interface {
+	// Message returns message for the HTTP status code in passed language. If message is not found - `false` will be
+	// returned as second value.
+	Message(lang string, code int) (string, bool)
+}
+
+// StaticMessageCatalog is a MessageCatalog implementation, based on the map (language => status code => message).
+type StaticMessageCatalog map[string]map[int]string
+
+// Message returns message for the HTTP status code in passed language.
+func (c StaticMessageCatalog) Message(lang string, code int) (string, bool) {
+	if messages, ok := c[lang]; ok {
+		if message, ok := messages[code]; ok {
+			return message, true
+		}
+	}
+
+	return "", false
+}
+
+// RequestLanguage returns the best (supported) language for the request, based on `Accept-Language` header. First
+// language from the `Settings.Languages` is used as default. Empty string will be returned, when languages are not
+// defined.
+func (fs *FileServer) RequestLanguage(r *http.Request) string {
+	languages := fs.configFor(r).settings.Languages
+
+	if len(languages) == 0 {
+		return ""
+	}
+
+	if lang, ok := negotiateLanguage(r.Header.Get("Accept-Language"), languages); ok {
+		return lang
+	}
+
+	return languages[0]
+}
+
+// StatusMessage returns (localized, if `FileServer.Messages` is set) message for the HTTP status code. Standard
+// status text is used as a fallback.
+func (fs *FileServer) StatusMessage(r *http.Request, code int) string {
+	if fs.Messages != nil {
+		if message, ok := fs.Messages.Message(fs.RequestLanguage(r), code); ok {
+			return message
+		}
+	}
+
+	return http.StatusText(code)
+}
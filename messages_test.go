@@ -0,0 +1,55 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticMessageCatalog_Message(t *testing.T) {
+	catalog := StaticMessageCatalog{"ru": {http.StatusNotFound: "Не найдено"}}
+
+	message, ok := catalog.Message("ru", http.StatusNotFound)
+	assert.True(t, ok)
+	assert.Equal(t, "Не найдено", message)
+
+	_, ok = catalog.Message("ru", http.StatusForbidden)
+	assert.False(t, ok)
+
+	_, ok = catalog.Message("en", http.StatusNotFound)
+	assert.False(t, ok)
+}
+
+func TestFileServer_StatusMessage(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	fs, _ := NewFileServer(Settings{FilesRoot: tmpDir})
+	assert.NotNil(t, fs)
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "ru")
+
+	assert.Equal(t, "", fs.RequestLanguage(req))
+	assert.Equal(t, "Not Found", fs.StatusMessage(req, http.StatusNotFound))
+
+	settings := fs.CurrentSettings()
+	settings.Languages = []string{"en", "ru"}
+	assert.NoError(t, fs.UpdateSettings(settings))
+	fs.Messages = StaticMessageCatalog{
+		"en": {http.StatusNotFound: "Nothing here"},
+		"ru": {http.StatusNotFound: "Не найдено"},
+	}
+
+	assert.Equal(t, "ru", fs.RequestLanguage(req))
+	assert.Equal(t, "Не найдено", fs.StatusMessage(req, http.StatusNotFound))
+	assert.Equal(t, "Forbidden", fs.StatusMessage(req, http.StatusForbidden)) // missing translation
+
+	req.Header.Set("Accept-Language", "de")
+
+	assert.Equal(t, "en", fs.RequestLanguage(req)) // default language
+	assert.Equal(t, "Nothing here", fs.StatusMessage(req, http.StatusNotFound))
+}
@@ -0,0 +1,138 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	errMmapUnsupported = errors.New("memory mapping is not supported")      //nolint:gochecknoglobals
+	errMmapFault       = errors.New("memory-mapped file is not accessible") //nolint:gochecknoglobals
+)
+
+// cacheableContent reads the file content for caching: files up to `CacheMaxFileSize` are read into memory, files up
+// to `CacheMmapMaxFileSize` are memory-mapped. Nil is returned, when the file can not be cached.
+func cacheableContent(cfg *config, f *resolvedFile) io.ReadSeeker {
+	switch size := f.info.Size(); {
+	case size <= cfg.settings.CacheMaxFileSize:
+		data, err := ioutil.ReadAll(f.file)
+		if err != nil {
+			return nil
+		}
+
+		return bytes.NewReader(data)
+
+	case size <= cfg.settings.CacheMmapMaxFileSize:
+		file, ok := f.file.(*os.File) // only local directory layers can be mapped
+		if !ok {
+			return nil
+		}
+
+		content, err := mmapFile(file, size)
+		if err != nil {
+			return nil
+		}
+
+		return content
+	}
+
+	return nil
+}
+
+// mmapContent is a read-only memory-mapped file content. Mapping is released, when the content is garbage collected.
+// Files must be replaced atomically (renamed), because access to the truncated file mapping raises the fault (it is
+// reported as read error).
+type mmapContent struct {
+	data   []byte
+	offset int64 // is used by Read and Seek
+}
+
+// newMmapContent wraps mapped data.
+func newMmapContent(data []byte) *mmapContent {
+	c := &mmapContent{data: data}
+	runtime.SetFinalizer(c, (*mmapContent).Close)
+
+	return c
+}
+
+// Size returns content size.
+func (c *mmapContent) Size() int64 { return int64(len(c.data)) }
+
+// ReadAt implements io.ReaderAt interface.
+func (c *mmapContent) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if off >= int64(len(c.data)) {
+		return 0, io.EOF
+	}
+
+	defer func() {
+		if recover() != nil {
+			n, err = 0, errMmapFault
+		}
+	}()
+
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+
+	if n = copy(b, c.data[off:]); n < len(b) {
+		err = io.EOF
+	}
+
+	runtime.KeepAlive(c) // mapping must not be released while data is copied
+
+	return n, err
+}
+
+// Read implements io.Reader interface.
+func (c *mmapContent) Read(b []byte) (int, error) {
+	n, err := c.ReadAt(b, c.offset)
+	c.offset += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Seek implements io.Seeker interface.
+func (c *mmapContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += int64(len(c.data))
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	c.offset = offset
+
+	return offset, nil
+}
+
+// Close releases the mapping.
+func (c *mmapContent) Close() error {
+	if c.data == nil {
+		return nil
+	}
+
+	runtime.SetFinalizer(c, nil)
+
+	data := c.data
+	c.data = nil
+
+	return munmap(data)
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package fileserver
+
+import "os"
+
+// mmapFile is a stub for the platforms without memory mapping support (files are not mapped).
+func mmapFile(*os.File, int64) (*mmapContent, error) { return nil, errMmapUnsupported }
+
+// munmap is a stub for the platforms without memory mapping support.
+func munmap([]byte) error { return nil }
@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package fileserver
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the file content (of passed size) into memory.
+func mmapFile(f *os.File, size int64) (*mmapContent, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errMmapUnsupported
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMmapContent(data), nil
+}
+
+// munmap releases the mapping.
+func munmap(data []byte) error { return syscall.Munmap(data) }
@@ -0,0 +1,114 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package fileserver
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileServer_ServeHTTP_MmapCache(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	content := strings.Repeat("0123456789", 10)
+
+	files := map[string]string{"small.txt": "small", "medium.txt": content, "large.txt": content + content}
+
+	for name, data := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(data), 0600))
+	}
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:            tmpDir,
+		CacheEnabled:         true,
+		CacheMaxFileSize:     8,
+		CacheMmapMaxFileSize: 100,
+	})
+	assert.NoError(t, err)
+
+	serve := func(uri, ranges string) *httptest.ResponseRecorder {
+		var (
+			req = httptest.NewRequest(http.MethodGet, uri, nil)
+			rr  = httptest.NewRecorder()
+		)
+
+		if ranges != "" {
+			req.Header.Set("Range", ranges)
+		}
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for _, uri := range []string{"/small.txt", "/medium.txt", "/large.txt"} {
+		assert.Equal(t, http.StatusOK, serve(uri, "").Code)
+	}
+
+	for name, wantType := range map[string]interface{}{"small.txt": nil, "medium.txt": &mmapContent{}} {
+		item, found := fs.Cache.Get(filepath.Join(tmpDir, name))
+
+		if assert.True(t, found, name) && wantType != nil {
+			assert.IsType(t, wantType, item.Content, name)
+		}
+	}
+
+	_, found := fs.Cache.Get(filepath.Join(tmpDir, "large.txt"))
+	assert.False(t, found, "large file is not cached")
+
+	// the file is replaced atomically, mapped content is not changed
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "medium.new"), []byte("changed"), 0600))
+	assert.NoError(t, os.Rename(filepath.Join(tmpDir, "medium.new"), filepath.Join(tmpDir, "medium.txt")))
+
+	assert.Equal(t, content, serve("/medium.txt", "").Body.String())
+
+	rr := serve("/medium.txt", "bytes=0-1,-3")
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, []byteRangesPart{{"bytes 0-1/100", "01"}, {"bytes 97-99/100", "789"}}, readByteRanges(t, rr))
+}
+
+func TestMmapContent(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	filePath := filepath.Join(tmpDir, "file")
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte(strings.Repeat("x", 1<<16)), 0600))
+
+	f, err := os.Open(filePath)
+	assert.NoError(t, err)
+
+	defer f.Close()
+
+	content, err := mmapFile(f, 1<<16)
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadAll(content)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1<<16)
+
+	pos, err := content.Seek(-2, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<16-2), pos)
+
+	// access to the truncated file mapping is reported as error
+	assert.NoError(t, os.Truncate(filePath, 0))
+
+	_, err = content.ReadAt(make([]byte, 8), 1<<15)
+	assert.Equal(t, errMmapFault, err)
+
+	assert.NoError(t, content.Close())
+	assert.NoError(t, content.Close())
+
+	_, err = content.ReadAt(make([]byte, 8), 0)
+	assert.Equal(t, io.EOF, err, "mapping is released")
+}
@@ -0,0 +1,136 @@
+package fileserver
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// qualityItem is a single element of HTTP header with quality values (like `Accept` or `Accept-Language`).
+type qualityItem struct {
+	Value string
+	Q     float64
+}
+
+// parseQualityHeader parses HTTP header with quality values (`text/html;q=0.8, */*;q=0.1`). Result is ordered by
+// quality (descending), items with equal quality keep their original order.
+func parseQualityHeader(header string) []qualityItem {
+	items := make([]qualityItem, 0, strings.Count(header, ",")+1)
+
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		if value == "" {
+			continue
+		}
+
+		item := qualityItem{Value: value, Q: 1}
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+
+			if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64); err == nil && q >= 0 && q <= 1 {
+					item.Q = q
+				} else {
+					item.Q = 0 // malformed quality value makes the item unacceptable
+				}
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Q > items[j].Q })
+
+	return items
+}
+
+// mediaRangeSpecificity returns how specific media range matches the media type (0 - not matched, 1 - `*/*`,
+// 2 - `type/*`, 3 - exact match).
+func mediaRangeSpecificity(mediaRange, mediaType string) int {
+	if mediaRange == mediaType {
+		return 3 //nolint:gomnd
+	}
+
+	if mediaRange == "*/*" {
+		return 1
+	}
+
+	if strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1]) {
+		return 2 //nolint:gomnd
+	}
+
+	return 0
+}
+
+// negotiateMediaType selects the best media type from the offers (ordered by server preference) for the `Accept`
+// header value. Empty header means "anything is acceptable" (first offer is returned).
+func negotiateMediaType(accept string, offers []string) (string, bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	var (
+		ranges = parseQualityHeader(accept)
+		best   string
+		bestQ  float64
+	)
+
+	for _, offer := range offers {
+		var (
+			mediaType   = strings.ToLower(offer)
+			specificity int
+			q           float64
+		)
+
+		// quality for the offer is defined by the most specific matched media range
+		for _, r := range ranges {
+			if s := mediaRangeSpecificity(r.Value, mediaType); s > specificity {
+				specificity, q = s, r.Q
+			}
+		}
+
+		if specificity > 0 && q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// negotiateLanguage selects the best language from the supported languages list for the `Accept-Language` header
+// value. Language tags are compared case-insensitively, and primary language subtag is used, when there is no exact
+// match (`en-US` matches `en` and vice versa).
+func negotiateLanguage(acceptLanguage string, supported []string) (string, bool) {
+	for _, item := range parseQualityHeader(acceptLanguage) {
+		if item.Q <= 0 {
+			continue
+		}
+
+		if item.Value == "*" && len(supported) > 0 {
+			return supported[0], true
+		}
+
+		for _, lang := range supported {
+			if strings.EqualFold(lang, item.Value) {
+				return lang, true
+			}
+		}
+
+		for _, lang := range supported {
+			lower := strings.ToLower(lang)
+
+			if strings.HasPrefix(item.Value, lower+"-") || strings.HasPrefix(lower, item.Value+"-") {
+				return lang, true
+			}
+		}
+	}
+
+	return "", false
+}
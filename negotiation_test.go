@@ -0,0 +1,79 @@
+package fileserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQualityHeader(t *testing.T) {
+	assert.Equal(t, []qualityItem{
+		{Value: "text/html", Q: 1},
+		{Value: "application/json", Q: 1},
+		{Value: "application/xml", Q: 0.9},
+		{Value: "*/*", Q: 0.1},
+		{Value: "image/png", Q: 0},
+	}, parseQualityHeader("application/xml;q=0.9, */*; q=0.1 ,text/HTML,image/png;q=foo, application/json;level=1,,"))
+
+	assert.Empty(t, parseQualityHeader(""))
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	offers := []string{MediaTypeHTML, MediaTypeJSON, MediaTypeProblemJSON, MediaTypePlainText}
+
+	for _, tt := range []struct {
+		giveAccept string
+		wantType   string
+		wantOk     bool
+	}{
+		{giveAccept: "", wantType: MediaTypeHTML, wantOk: true},
+		{giveAccept: "*/*", wantType: MediaTypeHTML, wantOk: true},
+		{giveAccept: "application/json", wantType: MediaTypeJSON, wantOk: true},
+		{giveAccept: "application/jsonp", wantOk: false},
+		{giveAccept: "image/png", wantOk: false},
+		{giveAccept: "application/problem+json", wantType: MediaTypeProblemJSON, wantOk: true},
+		{giveAccept: "text/html;q=0.5, application/json;q=0.8", wantType: MediaTypeJSON, wantOk: true},
+		{giveAccept: "text/*;q=0.5, application/json;q=0.4", wantType: MediaTypeHTML, wantOk: true},
+		{giveAccept: "text/html;q=0, text/*", wantType: MediaTypePlainText, wantOk: true},
+		{giveAccept: "application/*;q=0.3, */*;q=0.1", wantType: MediaTypeJSON, wantOk: true},
+		{giveAccept: "application/json;q=0", wantOk: false},
+		{
+			giveAccept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			wantType:   MediaTypeHTML,
+			wantOk:     true,
+		},
+	} {
+		mediaType, ok := negotiateMediaType(tt.giveAccept, offers)
+
+		assert.Equal(t, tt.wantOk, ok, tt.giveAccept)
+		assert.Equal(t, tt.wantType, mediaType, tt.giveAccept)
+	}
+
+	_, ok := negotiateMediaType("*/*", nil)
+	assert.False(t, ok)
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	supported := []string{"en", "ru", "pt-BR"}
+
+	for _, tt := range []struct {
+		giveAcceptLanguage string
+		wantLang           string
+		wantOk             bool
+	}{
+		{giveAcceptLanguage: "", wantOk: false},
+		{giveAcceptLanguage: "de", wantOk: false},
+		{giveAcceptLanguage: "ru", wantLang: "ru", wantOk: true},
+		{giveAcceptLanguage: "RU-ru, en;q=0.9", wantLang: "ru", wantOk: true},
+		{giveAcceptLanguage: "de, en-US;q=0.8, ru;q=0.5", wantLang: "en", wantOk: true},
+		{giveAcceptLanguage: "pt", wantLang: "pt-BR", wantOk: true},
+		{giveAcceptLanguage: "pt-br", wantLang: "pt-BR", wantOk: true},
+		{giveAcceptLanguage: "de, *;q=0.1", wantLang: "en", wantOk: true},
+		{giveAcceptLanguage: "ru;q=0, de", wantOk: false},
+	} {
+		lang, ok := negotiateLanguage(tt.giveAcceptLanguage, supported)
+
+		assert.Equal(t, tt.wantOk, ok, tt.giveAcceptLanguage)
+		assert.Equal(t, tt.wantLang, lang, tt.giveAcceptLanguage)
+	}
+}
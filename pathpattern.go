@@ -0,0 +1,22 @@
+package fileserver
+
+import (
+	"regexp"
+	"strings"
+)
+
+// compilePathPattern compiles URL path pattern into regular expression. Pattern can be a regular expression (when it
+// starts with `^`) or glob, where `*` matches any characters sequence (including `/`) and is captured as a group.
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "^") {
+		return regexp.Compile(pattern)
+	}
+
+	parts := strings.Split(pattern, "*")
+
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.Compile("^" + strings.Join(parts, "(.*)") + "$")
+}
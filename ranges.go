@@ -0,0 +1,260 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RangeSettings describes range requests policy (range requests are allowed for all paths by default).
+type RangeSettings struct {
+	// Path patterns (relative to the `Settings.BasePath`, see BandwidthRule for the syntax), where range requests are
+	// disabled: `Range` header is ignored (the whole content is responded) and `Accept-Ranges: none` is sent.
+	DisabledPaths []string
+
+	// Maximal ranges count in a single request. Requests with more ranges are responded with the whole content (it
+	// protects from the range amplification attacks). Zero means unlimited.
+	MaxRanges int
+
+	// Merge overlapping and adjacent ranges before the response sending (multipart response parts are ordered by the
+	// range start in this case).
+	Coalesce bool
+}
+
+// clone returns deep copy of the range settings.
+func (s RangeSettings) clone() RangeSettings {
+	s.DisabledPaths = append([]string(nil), s.DisabledPaths...)
+
+	return s
+}
+
+// validate checks range settings.
+func (s RangeSettings) validate(errs *ValidationErrors) {
+	for i, pattern := range s.DisabledPaths {
+		if _, err := compilePathPattern(pattern); err != nil {
+			errs.add(fmt.Sprintf("Ranges.DisabledPaths[%d]", i), "wrong pattern: %s", err)
+		}
+	}
+
+	if s.MaxRanges < 0 {
+		errs.add("Ranges.MaxRanges", "must not be negative")
+	}
+}
+
+// rangePolicy is a prepared RangeSettings.
+type rangePolicy struct {
+	disabled  []*regexp.Regexp
+	maxRanges int
+	coalesce  bool
+}
+
+// newRangePolicy prepares (already validated) range settings. Nil is returned for the default policy.
+func newRangePolicy(s RangeSettings) *rangePolicy {
+	if len(s.DisabledPaths) == 0 && s.MaxRanges == 0 && !s.Coalesce {
+		return nil
+	}
+
+	policy := &rangePolicy{maxRanges: s.MaxRanges, coalesce: s.Coalesce}
+
+	for _, pattern := range s.DisabledPaths {
+		if re, err := compilePathPattern(pattern); err == nil {
+			policy.disabled = append(policy.disabled, re)
+		}
+	}
+
+	return policy
+}
+
+// applyRangePolicy prepares response writer and request for the file content serving (using `http.ServeContent`)
+// according to the range requests policy.
+func (cfg *config) applyRangePolicy(
+	w http.ResponseWriter, r *http.Request, urlPath string, content io.ReadSeeker,
+) (http.ResponseWriter, *http.Request) {
+	if cfg.ranges == nil {
+		return w, r
+	}
+
+	return cfg.ranges.apply(w, r, urlPath, content)
+}
+
+// apply prepares response writer and request for the file content serving.
+func (p *rangePolicy) apply(
+	w http.ResponseWriter, r *http.Request, urlPath string, content io.ReadSeeker,
+) (http.ResponseWriter, *http.Request) {
+	for _, re := range p.disabled {
+		if re.MatchString(urlPath) {
+			return rangesDisabledResponseWriter{w}, withRangeHeader(r, "")
+		}
+	}
+
+	header := r.Header.Get("Range")
+	if header == "" || !strings.HasPrefix(header, "bytes=") {
+		return w, r // malformed header is processed by `http.ServeContent`
+	}
+
+	specs := make([]string, 0, 1)
+
+	for _, spec := range strings.Split(header[len("bytes="):], ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			specs = append(specs, spec)
+		}
+	}
+
+	if p.maxRanges > 0 && len(specs) > p.maxRanges {
+		return w, withRangeHeader(r, "")
+	}
+
+	if !p.coalesce || len(specs) < 2 { //nolint:gomnd
+		return w, r
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return w, r
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return w, r
+	}
+
+	if ranges, ok := parseByteRanges(specs, size); ok {
+		return w, withRangeHeader(r, formatByteRanges(coalesceByteRanges(ranges)))
+	}
+
+	return w, r
+}
+
+// withRangeHeader returns request copy with replaced (or removed, when the value is empty) `Range` header.
+func withRangeHeader(r *http.Request, value string) *http.Request {
+	r = r.Clone(r.Context())
+
+	if value == "" {
+		r.Header.Del("Range")
+	} else {
+		r.Header.Set("Range", value)
+	}
+
+	return r
+}
+
+// byteRange is an inclusive range of the content bytes.
+type byteRange struct {
+	start, end int64
+}
+
+// parseByteRanges parses range specs (like `0-99`, `100-` or `-50`) for the content of passed size. Unsatisfiable
+// ranges are skipped (like `http.ServeContent` does), false is returned for the malformed specs or when there are no
+// satisfiable ranges.
+func parseByteRanges(specs []string, size int64) ([]byteRange, bool) {
+	ranges := make([]byteRange, 0, len(specs))
+
+	for _, spec := range specs {
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil, false
+		}
+
+		start, end := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+		var r byteRange
+
+		if start == "" { // suffix range
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+
+			if n == 0 || size == 0 {
+				continue
+			}
+
+			if n > size {
+				n = size
+			}
+
+			r = byteRange{start: size - n, end: size - 1}
+		} else {
+			var err error
+
+			if r.start, err = strconv.ParseInt(start, 10, 64); err != nil || r.start < 0 {
+				return nil, false
+			}
+
+			if r.start >= size {
+				continue
+			}
+
+			r.end = size - 1
+
+			if end != "" {
+				if r.end, err = strconv.ParseInt(end, 10, 64); err != nil || r.start > r.end {
+					return nil, false
+				}
+
+				if r.end >= size {
+					r.end = size - 1
+				}
+			}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, len(ranges) > 0
+}
+
+// coalesceByteRanges sorts ranges and merges overlapping and adjacent ones.
+func coalesceByteRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	merged := ranges[:1]
+
+	for _, r := range ranges[1:] {
+		if last := &merged[len(merged)-1]; r.start <= last.end+1 {
+			if r.end > last.end {
+				last.end = r.end
+			}
+
+			continue
+		}
+
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// formatByteRanges formats ranges as a `Range` header value.
+func formatByteRanges(ranges []byteRange) string {
+	specs := make([]string, len(ranges))
+
+	for i, r := range ranges {
+		specs[i] = strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.end, 10)
+	}
+
+	return "bytes=" + strings.Join(specs, ",")
+}
+
+// rangesDisabledResponseWriter replaces `Accept-Ranges` header, that is set by `http.ServeContent`.
+type rangesDisabledResponseWriter struct {
+	http.ResponseWriter
+}
+
+// WriteHeader implements http.ResponseWriter interface.
+func (w rangesDisabledResponseWriter) WriteHeader(statusCode int) {
+	w.Header().Set("Accept-Ranges", "none")
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// ReadFrom implements io.ReaderFrom interface, so "zero-copy" sending of the underlying writer is not lost.
+func (w rangesDisabledResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+
+	return io.Copy(w.ResponseWriter, src)
+}
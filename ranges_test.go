@@ -0,0 +1,177 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rangesTestContent = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// byteRangesPart is a part of the multipart/byteranges response.
+type byteRangesPart struct {
+	contentRange, content string
+}
+
+// readByteRanges reads parts of the multipart/byteranges response.
+func readByteRanges(t *testing.T, rr *httptest.ResponseRecorder) []byteRangesPart {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	if !assert.NoError(t, err) || !assert.Equal(t, "multipart/byteranges", mediaType) {
+		return nil
+	}
+
+	var (
+		parts  []byteRangesPart
+		reader = multipart.NewReader(rr.Body, params["boundary"])
+	)
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return parts
+		}
+
+		content, _ := ioutil.ReadAll(part)
+		parts = append(parts, byteRangesPart{part.Header.Get("Content-Range"), string(content)})
+	}
+}
+
+func TestFileServer_ServeHTTP_Ranges(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "video"), 0700))
+
+	for _, name := range []string{"small.txt", "large.txt", "video/clip.txt"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(rangesTestContent), 0600))
+	}
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "large.txt"),
+		[]byte(strings.Repeat(rangesTestContent, 2)), 0600))
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:        tmpDir,
+		CacheEnabled:     true,
+		CacheMaxFileSize: int64(len(rangesTestContent)),
+		Ranges: RangeSettings{
+			DisabledPaths: []string{"/video/*"},
+			MaxRanges:     3,
+			Coalesce:      true,
+		},
+	})
+	assert.NoError(t, err)
+
+	serve := func(uri, ranges string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, uri, nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("Range", ranges)
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for _, tc := range []struct {
+		name   string
+		uri    string
+		ranges string
+		want   []byteRangesPart
+	}{
+		{
+			name:   "cache miss",
+			uri:    "/small.txt",
+			ranges: "bytes=0-1, 10-12",
+			want:   []byteRangesPart{{"bytes 0-1/36", "01"}, {"bytes 10-12/36", "abc"}},
+		},
+		{
+			name:   "from cache",
+			uri:    "/small.txt",
+			ranges: "bytes=-2, 4-5",
+			want:   []byteRangesPart{{"bytes 4-5/36", "45"}, {"bytes 34-35/36", "yz"}},
+		},
+		{
+			name:   "from disk (not cacheable)",
+			uri:    "/large.txt",
+			ranges: "bytes=36-37,70-",
+			want:   []byteRangesPart{{"bytes 36-37/72", "01"}, {"bytes 70-71/72", "yz"}},
+		},
+	} {
+		rr := serve(tc.uri, tc.ranges)
+		assert.Equal(t, http.StatusPartialContent, rr.Code, tc.name)
+		assert.Equal(t, tc.want, readByteRanges(t, rr), tc.name)
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/small.txt", "bytes=20-29,0-3,2-5,6-7").Code, "too many ranges")
+
+	rr := serve("/small.txt", "bytes=20-29,0-3,2-7")
+	assert.Equal(t, http.StatusPartialContent, rr.Code, "overlapping ranges are coalesced")
+	assert.Equal(t, []byteRangesPart{{"bytes 0-7/36", "01234567"}, {"bytes 20-29/36", "klmnopqrst"}},
+		readByteRanges(t, rr))
+
+	rr = serve("/small.txt", "bytes=4-7,0-3")
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "bytes 0-7/36", rr.Header().Get("Content-Range"), "single range after coalescing")
+	assert.Equal(t, "01234567", rr.Body.String())
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, serve("/small.txt", "bytes=40-50,60-").Code)
+
+	for _, uri := range []string{"/video/clip.txt", "/./video/clip.txt", "/small/../video/clip.txt"} {
+		rr = serve(uri, "bytes=0-1")
+		assert.Equal(t, http.StatusOK, rr.Code, "ranges are disabled for "+uri)
+		assert.Equal(t, "none", rr.Header().Get("Accept-Ranges"), uri)
+		assert.Equal(t, rangesTestContent, rr.Body.String(), uri)
+	}
+}
+
+func TestFileServer_ServeHTTP_ConcurrentCachedRanges(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte(rangesTestContent), 0600))
+
+	fs, err := NewFileServer(Settings{FilesRoot: tmpDir, CacheEnabled: true})
+	assert.NoError(t, err)
+
+	fs.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/file.txt", nil)) // warm up the cache
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			var (
+				req = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+				rr  = httptest.NewRecorder()
+				at  = strconv.Itoa(i + 10)
+			)
+
+			req.Header.Set("Range", "bytes=0-1,"+at+"-"+at+",-3")
+			fs.ServeHTTP(rr, req)
+
+			assert.Equal(t, []byteRangesPart{
+				{"bytes 0-1/36", "01"},
+				{"bytes " + at + "-" + at + "/36", rangesTestContent[i+10 : i+11]},
+				{"bytes 33-35/36", "xyz"},
+			}, readByteRanges(t, rr))
+		}(i)
+	}
+
+	wg.Wait()
+}
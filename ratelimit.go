@@ -0,0 +1,311 @@
+package fileserver
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimitMaxClients = 10000
+	inFlightRetryAfter         = time.Second
+)
+
+// RateLimitSettings describes requests limiting options. Rejected requests are responded with "429 Too Many Requests"
+// and `Retry-After` header.
+type RateLimitSettings struct {
+	// Allowed requests rate per client (token bucket refill rate). Zero disables per-client rate limiting.
+	RequestsPerSecond float64
+
+	// Maximal requests burst per client (token bucket size, `ceil(RequestsPerSecond)` by default).
+	Burst int
+
+	// Client key function (has the highest priority, client IP address is used, when it returns empty string).
+	ClientKey func(r *http.Request) string
+
+	// Request header with the client key (like `X-Real-IP` or `X-Forwarded-For`, the first list value is used). Client
+	// IP address is used by default.
+	ClientKeyHeader string
+
+	// Maximal tracked clients count (least recently seen clients are forgotten first, 10000 by default). Clients with
+	// active large file streams are not forgotten.
+	MaxClients int
+
+	// Maximal concurrently processed requests count (for the whole server). Zero means unlimited.
+	MaxInFlight int
+
+	// File size (in bytes), starting from which file streaming (from the file system, not from the cache) is considered
+	// as "large".
+	LargeFileSize int64
+
+	// Maximal concurrent large file streams (for the whole server). Zero means unlimited.
+	MaxLargeStreams int
+
+	// Maximal concurrent large file streams per client. Zero means unlimited.
+	MaxLargeStreamsPerClient int
+}
+
+// enabled checks that any limit is set.
+func (s RateLimitSettings) enabled() bool {
+	return s.RequestsPerSecond > 0 || s.MaxInFlight > 0 || s.MaxLargeStreams > 0 || s.MaxLargeStreamsPerClient > 0
+}
+
+// validate checks rate limiting settings.
+func (s RateLimitSettings) validate(errs *ValidationErrors) {
+	if s.RequestsPerSecond < 0 || math.IsNaN(s.RequestsPerSecond) || math.IsInf(s.RequestsPerSecond, 0) {
+		errs.add("RateLimit.RequestsPerSecond", "must be a finite non-negative number")
+	}
+
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"Burst", int64(s.Burst)},
+		{"MaxClients", int64(s.MaxClients)},
+		{"MaxInFlight", int64(s.MaxInFlight)},
+		{"LargeFileSize", s.LargeFileSize},
+		{"MaxLargeStreams", int64(s.MaxLargeStreams)},
+		{"MaxLargeStreamsPerClient", int64(s.MaxLargeStreamsPerClient)},
+	} {
+		if f.value < 0 {
+			errs.add("RateLimit."+f.name, "must not be negative")
+		}
+	}
+
+	if (s.MaxLargeStreams > 0 || s.MaxLargeStreamsPerClient > 0) && s.LargeFileSize <= 0 {
+		errs.add("RateLimit.LargeFileSize", "must be set for the large file streams limiting")
+	}
+}
+
+// withDefaults returns settings with default values for the empty fields.
+func (s RateLimitSettings) withDefaults() RateLimitSettings {
+	if s.Burst == 0 && s.RequestsPerSecond > 0 {
+		s.Burst = int(math.Ceil(s.RequestsPerSecond))
+	}
+
+	if s.MaxClients == 0 {
+		s.MaxClients = defaultRateLimitMaxClients
+	}
+
+	return s
+}
+
+// tokenBucket is a classic token bucket (is not thread-safe).
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64 // bucket size
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// refill adds tokens for the time passed since the last refill.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// take takes n tokens, if it is possible. Otherwise nothing is taken and waiting time (until tokens will be
+// available) is returned.
+func (b *tokenBucket) take(now time.Time, n float64) (bool, time.Duration) {
+	b.refill(now)
+
+	if b.tokens >= n {
+		b.tokens -= n
+
+		return true, 0
+	}
+
+	return false, time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// reserve takes n tokens (tokens count can become negative) and returns waiting time, after which taken tokens can be
+// used.
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter limits requests rate per client and concurrent requests (and large file streams) count. Memory usage is
+// bounded by the tracked clients count.
+type rateLimiter struct {
+	settings RateLimitSettings
+
+	mu           sync.Mutex
+	inFlight     int
+	largeStreams int
+	clients      map[string]*list.Element // values are *limitedClient
+	lru          *list.List               // most recently seen clients go first
+}
+
+// limitedClient is a single client limits state.
+type limitedClient struct {
+	key          string
+	bucket       *tokenBucket // nil, if requests rate is not limited
+	largeStreams int
+}
+
+// newRateLimiter creates limiter for the prepared (see withDefaults) settings. Nil is returned, when limits are not
+// set.
+func newRateLimiter(s RateLimitSettings) *rateLimiter {
+	if !s.enabled() {
+		return nil
+	}
+
+	return &rateLimiter{
+		settings: s,
+		clients:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// clientKey returns the request client key.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	if l.settings.ClientKey != nil {
+		if key := l.settings.ClientKey(r); key != "" {
+			return key
+		}
+	}
+
+	if l.settings.ClientKeyHeader != "" {
+		if value := r.Header.Get(l.settings.ClientKeyHeader); value != "" {
+			if i := strings.IndexByte(value, ','); i >= 0 {
+				value = value[:i]
+			}
+
+			if value = strings.TrimSpace(value); value != "" {
+				return value
+			}
+		}
+	}
+
+	return clientIP(r)
+}
+
+// client returns (and marks as recently seen) client state. Least recently seen client is forgotten, when clients
+// limit is reached (clients with active large streams are kept, so their streams limit can not be bypassed). Must be
+// called under lock.
+func (l *rateLimiter) client(key string, now time.Time) *limitedClient {
+	if el, ok := l.clients[key]; ok {
+		l.lru.MoveToFront(el)
+
+		return el.Value.(*limitedClient)
+	}
+
+	for el := l.lru.Back(); el != nil && l.lru.Len() >= l.settings.MaxClients; {
+		prev := el.Prev()
+
+		if c := el.Value.(*limitedClient); c.largeStreams == 0 {
+			l.lru.Remove(el)
+			delete(l.clients, c.key)
+		}
+
+		el = prev
+	}
+
+	c := &limitedClient{key: key}
+
+	if l.settings.RequestsPerSecond > 0 {
+		c.bucket = newTokenBucket(l.settings.RequestsPerSecond, float64(l.settings.Burst), now)
+	}
+
+	l.clients[key] = l.lru.PushFront(c)
+
+	return c
+}
+
+// limiterTicket is a permission for the request processing. It must be released after the request processing.
+type limiterTicket struct {
+	limiter     *rateLimiter
+	client      *limitedClient
+	largeStream bool
+}
+
+// acquire checks the request limits. Nil ticket and time to wait are returned, when the request is rejected.
+func (l *rateLimiter) acquire(r *http.Request, now time.Time) (*limiterTicket, time.Duration) {
+	key := l.clientKey(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.settings.MaxInFlight > 0 && l.inFlight >= l.settings.MaxInFlight {
+		return nil, inFlightRetryAfter
+	}
+
+	c := l.client(key, now)
+
+	if c.bucket != nil {
+		if ok, wait := c.bucket.take(now, 1); !ok {
+			return nil, wait
+		}
+	}
+
+	l.inFlight++
+
+	return &limiterTicket{limiter: l, client: c}, 0
+}
+
+// acquireLargeStream checks large file streams limits for the file size. False is returned, when the stream is not
+// allowed.
+func (t *limiterTicket) acquireLargeStream(size int64) bool {
+	l := t.limiter
+
+	if l.settings.LargeFileSize <= 0 || size < l.settings.LargeFileSize || t.largeStream {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if (l.settings.MaxLargeStreams > 0 && l.largeStreams >= l.settings.MaxLargeStreams) ||
+		(l.settings.MaxLargeStreamsPerClient > 0 && t.client.largeStreams >= l.settings.MaxLargeStreamsPerClient) {
+		return false
+	}
+
+	l.largeStreams++
+	t.client.largeStreams++
+	t.largeStream = true
+
+	return true
+}
+
+// release releases the ticket.
+func (t *limiterTicket) release() {
+	l := t.limiter
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+
+	if t.largeStream {
+		l.largeStreams--
+		t.client.largeStreams--
+	}
+}
+
+// tooManyRequests responds with "429 Too Many Requests" and `Retry-After` header.
+func (fs *FileServer) tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	fs.handleError(w, r, http.StatusTooManyRequests)
+}
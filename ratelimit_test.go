@@ -0,0 +1,204 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 3, now)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := b.take(now, 1)
+		assert.True(t, ok)
+	}
+
+	ok, wait := b.take(now, 1)
+	assert.False(t, ok)
+	assert.Equal(t, time.Millisecond*500, wait)
+
+	ok, _ = b.take(now.Add(time.Millisecond*500), 1)
+	assert.True(t, ok)
+
+	b.refill(now.Add(time.Hour))
+	assert.Equal(t, float64(3), b.tokens, "tokens count is limited by the bucket size")
+}
+
+func TestRateLimiter_Acquire(t *testing.T) {
+	l := newRateLimiter(RateLimitSettings{
+		RequestsPerSecond:        1,
+		MaxInFlight:              3,
+		LargeFileSize:            100,
+		MaxLargeStreams:          2,
+		MaxLargeStreamsPerClient: 1,
+		ClientKeyHeader:          "X-Forwarded-For",
+		MaxClients:               2,
+	}.withDefaults())
+
+	request := func(client string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", client+", 10.0.0.1")
+
+		return req
+	}
+
+	now := time.Now()
+
+	first, _ := l.acquire(request("a"), now)
+	assert.NotNil(t, first)
+
+	rejected, retryAfter := l.acquire(request("a"), now)
+	assert.Nil(t, rejected, "rate limit")
+	assert.Equal(t, time.Second, retryAfter)
+
+	second, _ := l.acquire(request("b"), now)
+	third, _ := l.acquire(request("c"), now)
+	assert.NotNil(t, second)
+	assert.NotNil(t, third)
+	assert.Len(t, l.clients, 2, "clients count is limited")
+
+	rejected, retryAfter = l.acquire(request("d"), now)
+	assert.Nil(t, rejected, "in-flight limit")
+	assert.Equal(t, inFlightRetryAfter, retryAfter)
+
+	// large streams
+	assert.True(t, first.acquireLargeStream(99), "small file")
+	assert.True(t, first.acquireLargeStream(100))
+	assert.True(t, first.acquireLargeStream(100), "the same stream")
+	assert.True(t, second.acquireLargeStream(1000))
+	assert.False(t, third.acquireLargeStream(1000), "global large streams limit")
+
+	first.release()
+	assert.True(t, third.acquireLargeStream(1000))
+
+	fourth, _ := l.acquire(request("c"), now.Add(time.Second))
+	assert.NotNil(t, fourth)
+	assert.False(t, fourth.acquireLargeStream(1000), "per-client large streams limit")
+
+	for _, ticket := range []*limiterTicket{second, third, fourth} {
+		ticket.release()
+	}
+
+	assert.Equal(t, 0, l.inFlight)
+	assert.Equal(t, 0, l.largeStreams)
+}
+
+func TestRateLimiter_ClientsEviction(t *testing.T) {
+	l := newRateLimiter(RateLimitSettings{
+		LargeFileSize:            100,
+		MaxLargeStreamsPerClient: 1,
+		ClientKeyHeader:          "X-Real-IP",
+		MaxClients:               1,
+	}.withDefaults())
+
+	request := func(client string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", client)
+
+		return req
+	}
+
+	now := time.Now()
+
+	streaming, _ := l.acquire(request("a"), now)
+	assert.True(t, streaming.acquireLargeStream(100))
+
+	other, _ := l.acquire(request("b"), now)
+	assert.Len(t, l.clients, 2, "client with active large stream is not forgotten")
+
+	again, _ := l.acquire(request("a"), now)
+	assert.False(t, again.acquireLargeStream(100), "per-client large streams limit")
+
+	for _, ticket := range []*limiterTicket{streaming, other, again} {
+		ticket.release()
+	}
+
+	last, _ := l.acquire(request("c"), now)
+	assert.NotNil(t, last)
+	assert.Len(t, l.clients, 1)
+}
+
+func TestRateLimiter_ClientKey(t *testing.T) {
+	l := newRateLimiter(RateLimitSettings{
+		RequestsPerSecond: 1,
+		ClientKeyHeader:   "X-Real-IP",
+		ClientKey:         func(r *http.Request) string { return r.Header.Get("X-Api-Key") },
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal(t, "192.0.2.1", l.clientKey(req))
+
+	req.Header.Set("X-Real-IP", " 192.0.2.2 ")
+	assert.Equal(t, "192.0.2.2", l.clientKey(req))
+
+	req.Header.Set("X-Api-Key", "key")
+	assert.Equal(t, "key", l.clientKey(req))
+}
+
+func TestFileServer_ServeHTTP_RateLimit(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "index.html"), []byte("index"), 0600))
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot: tmpDir,
+		RateLimit: RateLimitSettings{RequestsPerSecond: 0.1, Burst: 2},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, defaultRateLimitMaxClients, fs.CurrentSettings().RateLimit.MaxClients, "defaults are applied")
+
+	serve := func(client string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, "/", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.RemoteAddr = client + ":1234"
+		req.Header.Set("Accept", "application/json")
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, serve("192.0.2.1").Code)
+	assert.Equal(t, http.StatusOK, serve("192.0.2.1").Code)
+
+	rr := serve("192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.True(t, strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json"), "error handlers are used")
+
+	retryAfter, _ := strconv.Atoi(rr.Header().Get("Retry-After"))
+	assert.InDelta(t, 10, retryAfter, 1)
+
+	assert.Equal(t, http.StatusOK, serve("192.0.2.2").Code, "another client")
+	assert.Equal(t, 0, fs.config().limiter.inFlight, "tickets are released")
+}
+
+func TestSettings_Validate_RateLimit(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{FilesRoot: tmpDir, RateLimit: RateLimitSettings{
+		RequestsPerSecond: -1,
+		Burst:             -1,
+		MaxLargeStreams:   1,
+	}}.Validate()
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"RateLimit.RequestsPerSecond", "RateLimit.Burst", "RateLimit.LargeFileSize"},
+		err.(ValidationErrors).Fields())
+}
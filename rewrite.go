@@ -0,0 +1,218 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxRewrites limits internal rewrites count for the single request (rewriting loops protection).
+const maxRewrites = 10
+
+// defaultRedirectsFileStatus is used for the redirects file rules without status code.
+const defaultRedirectsFileStatus = http.StatusMovedPermanently
+
+var errRewriteLoop = errors.New("too many rewrites") //nolint:gochecknoglobals
+
+// RewriteRule describes URL path rewriting (or redirection) rule. Paths are relative to the `Settings.BasePath`.
+type RewriteRule struct {
+	// Source path pattern: regular expression (when it starts with `^`) or glob (`*` matches any characters sequence,
+	// including `/`). Captured groups can be used in the target as `$1` or `${1}` (`:splat` is the same as `${1}`).
+	From string
+
+	// Target path (or absolute URL for redirects). Query string is allowed. Redirect target, that is not an absolute
+	// URL, always points to the local path (captured groups can not change the host).
+	To string
+
+	// Status code for the external redirect (301, 302, 303, 307 or 308). Use 0 (or 200) for the internal rewrite.
+	Status int
+}
+
+// rewriteRule is a compiled RewriteRule.
+type rewriteRule struct {
+	from     *regexp.Regexp
+	to       string
+	status   int
+	external bool // target is an absolute URL (with scheme or host)
+}
+
+// isRedirect checks that rule is an external redirect (not internal rewrite).
+func (r *rewriteRule) isRedirect() bool { return r.status != 0 && r.status != http.StatusOK }
+
+func compileRewriteRule(rule RewriteRule) (*rewriteRule, error) {
+	from, err := compilePathPattern(rule.From)
+	if err != nil {
+		return nil, fmt.Errorf("wrong pattern: %w", err)
+	}
+
+	if rule.To == "" {
+		return nil, errors.New("target must be set")
+	}
+
+	switch rule.Status {
+	case 0, http.StatusOK, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("unsupported status code %d", rule.Status)
+	}
+
+	compiled := &rewriteRule{
+		from:   from,
+		to:     strings.ReplaceAll(rule.To, ":splat", "${1}"),
+		status: rule.Status,
+	}
+
+	if u, err := url.Parse(rule.To); err == nil {
+		compiled.external = u.Scheme != "" || u.Host != ""
+	}
+
+	return compiled, nil
+}
+
+// compileRewriteRules compiles (already validated) rules. Wrong rules are skipped.
+func compileRewriteRules(rules []RewriteRule) []*rewriteRule {
+	result := make([]*rewriteRule, 0, len(rules))
+
+	for _, rule := range rules {
+		if compiled, err := compileRewriteRule(rule); err == nil {
+			result = append(result, compiled)
+		}
+	}
+
+	return result
+}
+
+// parseRedirectsFile parses redirects file content. Every non-empty line (except comments, started with `#`) must
+// contain source pattern, target and (optional, 301 by default) status code, separated by spaces:
+//
+//	/old-page      /new-page
+//	/blog/*        /posts/:splat   302
+//	^/id/(\d+)$    /items/$1.html  200
+func parseRedirectsFile(data []byte) ([]*rewriteRule, error) {
+	var (
+		rules   = make([]*rewriteRule, 0)
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+	)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		rule := RewriteRule{From: fields[0], Status: defaultRedirectsFileStatus}
+
+		switch len(fields) {
+		case 2: //nolint:gomnd
+			rule.To = fields[1]
+
+		case 3: //nolint:gomnd
+			status, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: wrong status code: %w", lineNumber, err)
+			}
+
+			rule.To, rule.Status = fields[1], status
+
+		default:
+			return nil, fmt.Errorf("line %d: wrong rule format", lineNumber)
+		}
+
+		compiled, err := compileRewriteRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	return rules, scanner.Err()
+}
+
+// rewriteRules returns rewriting rules from the settings and redirects file (settings rules go first).
+func (cfg *config) rewriteRules() []*rewriteRule {
+	if cfg.redirectsFile == nil {
+		return cfg.rewrites
+	}
+
+	fileRules, _ := cfg.redirectsFile.Value().([]*rewriteRule)
+
+	if len(fileRules) == 0 {
+		return cfg.rewrites
+	}
+
+	return append(append(make([]*rewriteRule, 0, len(cfg.rewrites)+len(fileRules)), cfg.rewrites...), fileRules...)
+}
+
+// rewrite applies rewriting rules to the URL path. Internal rewrites are applied repeatedly (until no rule matches),
+// rewritten path is returned with the query string (target query is merged with the original one). Redirect location
+// is returned for the external redirect rules (with original query string).
+func (cfg *config) rewrite(urlPath, rawQuery string) (newPath, newQuery, location string, status int, err error) {
+	rules := cfg.rewriteRules()
+
+	for rewrites := 0; ; rewrites++ {
+		rule, target, matched := matchRewriteRule(rules, urlPath)
+		if !matched {
+			return urlPath, rawQuery, "", 0, nil
+		}
+
+		targetPath, targetQuery := target, ""
+
+		if i := strings.IndexByte(target, '?'); i >= 0 {
+			targetPath, targetQuery = target[:i], target[i+1:]
+		}
+
+		if rawQuery != "" {
+			if targetQuery != "" {
+				targetQuery += "&"
+			}
+
+			targetQuery += rawQuery
+		}
+
+		if rule.isRedirect() {
+			if !rule.external {
+				// captured groups must not turn the local target into another host URL (like `//example.com`)
+				targetPath = cfg.settings.BasePath + "/" + strings.TrimLeft(targetPath, `/\`)
+			}
+
+			if targetQuery != "" {
+				targetPath += "?" + targetQuery
+			}
+
+			return urlPath, rawQuery, targetPath, rule.status, nil
+		}
+
+		if !strings.HasPrefix(targetPath, "/") {
+			targetPath = "/" + targetPath
+		}
+
+		if targetPath == urlPath { // rule target matches the rule itself (like `/* /index.html 200`)
+			return urlPath, rawQuery, "", 0, nil
+		}
+
+		if rewrites >= maxRewrites {
+			return urlPath, rawQuery, "", 0, errRewriteLoop
+		}
+
+		urlPath, rawQuery = targetPath, targetQuery
+	}
+}
+
+// matchRewriteRule returns the first matched rule and its target (with expanded captured groups).
+func matchRewriteRule(rules []*rewriteRule, urlPath string) (*rewriteRule, string, bool) {
+	for _, rule := range rules {
+		if match := rule.from.FindStringSubmatchIndex(urlPath); match != nil {
+			return rule, string(rule.from.ExpandString(nil, rule.to, urlPath, match)), true
+		}
+	}
+
+	return nil, "", false
+}
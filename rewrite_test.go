@@ -0,0 +1,227 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRedirectsFile(t *testing.T) {
+	rules, err := parseRedirectsFile([]byte("# comment\n\n/old   /new\n/blog/*  /posts/:splat  302\n^/id/(\\d+)$ /items/$1 200\n"))
+	assert.NoError(t, err)
+	assert.Len(t, rules, 3)
+
+	assert.Equal(t, http.StatusMovedPermanently, rules[0].status)
+	assert.Equal(t, "/posts/${1}", rules[1].to)
+	assert.Equal(t, http.StatusFound, rules[1].status)
+	assert.False(t, rules[2].isRedirect())
+
+	for _, content := range []string{
+		"/old",
+		"/old /new 301 extra",
+		"/old /new abc",
+		"/old /new 404",
+		"^/(unclosed /new",
+	} {
+		_, err := parseRedirectsFile([]byte("# comment\n" + content))
+		assert.Error(t, err, content)
+		assert.Contains(t, err.Error(), "line 2", content)
+	}
+}
+
+func TestConfig_Rewrite(t *testing.T) {
+	cfg := newConfig(Settings{
+		BasePath: "/static",
+		RewriteRules: []RewriteRule{
+			{From: "/docs/*", To: "/manual/:splat"},
+			{From: "/manual/*", To: "/guide/$1?from=manual", Status: http.StatusOK},
+			{From: "/old/*", To: "/new/:splat", Status: http.StatusMovedPermanently},
+			{From: `^/user/(\d+)$`, To: "/users/${1}.html", Status: http.StatusFound},
+			{From: "/external", To: "https://example.com/", Status: http.StatusTemporaryRedirect},
+			{From: "/spa/*", To: "/spa/index.html"},
+			{From: "/go/*", To: "/:splat", Status: http.StatusFound},
+			{From: "/jump/*", To: "$1", Status: http.StatusFound},
+			{From: "/loop/a", To: "/loop/b"},
+			{From: "/loop/b", To: "/loop/a"},
+		},
+	})
+
+	for _, tt := range []struct {
+		name         string
+		path, query  string
+		wantPath     string
+		wantQuery    string
+		wantLocation string
+		wantStatus   int
+	}{
+		{name: "rewrites chain", path: "/docs/a/b.html", wantPath: "/guide/a/b.html", wantQuery: "from=manual"},
+		{name: "query merging", path: "/docs/x", query: "q=1", wantPath: "/guide/x", wantQuery: "from=manual&q=1"},
+		{name: "redirect", path: "/old/a.html", query: "q=1", wantLocation: "/static/new/a.html?q=1", wantStatus: 301},
+		{name: "regexp", path: "/user/42", wantLocation: "/static/users/42.html", wantStatus: 302},
+		{name: "absolute URL", path: "/external", wantLocation: "https://example.com/", wantStatus: 307},
+		{name: "captured host", path: "/go//evil.com", wantLocation: "/static/evil.com", wantStatus: 302},
+		{name: "captured backslash", path: `/go/\\evil.com`, wantLocation: "/static/evil.com", wantStatus: 302},
+		{name: "captured URL", path: "/jump/https://evil.com", wantLocation: "/static/https://evil.com", wantStatus: 302},
+		{name: "self matching target", path: "/spa/index.html", wantPath: "/spa/index.html"},
+		{name: "fallback", path: "/spa/any/page", wantPath: "/spa/index.html"},
+		{name: "not matched", path: "/any/page", query: "q=1", wantPath: "/any/page", wantQuery: "q=1"},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			newPath, newQuery, location, status, err := cfg.rewrite(tt.path, tt.query)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLocation, location)
+			assert.Equal(t, tt.wantStatus, status)
+
+			if tt.wantLocation == "" {
+				assert.Equal(t, tt.wantPath, newPath)
+				assert.Equal(t, tt.wantQuery, newQuery)
+			}
+		})
+	}
+
+	_, _, _, _, err := cfg.rewrite("/loop/a", "")
+	assert.Equal(t, errRewriteLoop, err)
+}
+
+func TestFileServer_ServeHTTP_Rewrites(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for name, content := range map[string]string{
+		"index.html": "index",
+		"page.html":  "page",
+		"_redirects": "/from-file /page.html 200\n/moved /page.html 308\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:         tmpDir,
+		BasePath:          "/site",
+		RedirectsFileName: "_redirects",
+		RewriteRules: []RewriteRule{
+			{From: "/app/*", To: "/index.html"},
+			{From: "/loop", To: "/loop2"},
+			{From: "/loop2", To: "/loop"},
+		},
+	})
+	assert.NoError(t, err)
+
+	serve := func(uri string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, uri, nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	assert.Equal(t, "index", serve("/site/app/some/route").Body.String())
+	assert.Equal(t, "page", serve("/site/from-file").Body.String())
+	assert.Equal(t, http.StatusNotFound, serve("/site/_redirects").Code)
+	assert.Equal(t, http.StatusInternalServerError, serve("/site/loop").Code)
+
+	rr := serve("/site/moved?a=b")
+	assert.Equal(t, http.StatusPermanentRedirect, rr.Code)
+	assert.Equal(t, "/site/page.html?a=b", rr.Header().Get("Location"))
+
+	// redirects file changes are applied in runtime
+	fs.config().redirectsFile.checkInterval = 0
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "_redirects"), []byte("/moved /index.html 302\n"), 0600))
+	assert.NoError(t, os.Chtimes(filepath.Join(tmpDir, "_redirects"), time.Now(), time.Now().Add(time.Minute)))
+
+	rr = serve("/site/moved")
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "/site/index.html", rr.Header().Get("Location"))
+	assert.Equal(t, http.StatusNotFound, serve("/site/from-file").Code)
+
+	// unparsable file changes are ignored
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "_redirects"), []byte("wrong"), 0600))
+	assert.Equal(t, http.StatusFound, serve("/site/moved").Code)
+}
+
+func TestFileServer_ServeHTTP_RewriteQuery(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:    tmpDir,
+		RewriteRules: []RewriteRule{{From: "/search", To: "/index.html?mode=x"}},
+	})
+	assert.NoError(t, err)
+
+	var query string
+
+	fs.ErrorHandlers = []ErrorHandlerFunc{func(w http.ResponseWriter, r *http.Request, _ *FileServer, code int) bool {
+		query = r.URL.RawQuery
+		w.WriteHeader(code)
+
+		return true
+	}}
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=1", nil)
+	fs.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "mode=x&q=1", query, "target query is applied")
+	assert.Equal(t, "q=1", req.URL.RawQuery, "original request is not modified")
+}
+
+func TestFileServer_ServeHTTP_RewritesWithIndexRedirect(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "index.html"), []byte("app"), 0600))
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:               tmpDir,
+		IndexFileName:           "index.html",
+		RedirectIndexFileToRoot: true,
+		RewriteRules:            []RewriteRule{{From: "/*", To: "/index.html", Status: http.StatusOK}}, // SPA fallback
+	})
+	assert.NoError(t, err)
+
+	for _, uri := range []string{"/", "/app/route"} {
+		rr := httptest.NewRecorder()
+		fs.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, uri, nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code, uri)
+		assert.Equal(t, "app", rr.Body.String(), uri)
+	}
+
+	rr := httptest.NewRecorder()
+	fs.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code, "requested index file is redirected")
+	assert.Equal(t, "/", rr.Header().Get("Location"))
+}
+
+func TestSettings_Validate_RewriteRules(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{
+		FilesRoot:         tmpDir,
+		RedirectsFileName: "../_redirects",
+		RewriteRules: []RewriteRule{
+			{From: "/ok", To: "/fine"},
+			{From: "^/(", To: "/x"},
+			{From: "/a", To: ""},
+			{From: "/b", To: "/c", Status: http.StatusNotFound},
+		},
+	}.Validate()
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"RewriteRules[1]", "RewriteRules[2]", "RewriteRules[3]", "RedirectsFileName"},
+		err.(ValidationErrors).Fields())
+}
@@ -0,0 +1,258 @@
+package fileserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signed URL query parameter names.
+const (
+	SignedURLExpiresParam   = "expires"
+	SignedURLKeyParam       = "key"
+	SignedURLSignatureParam = "signature"
+)
+
+const minSigningKeySecretLength = 16
+
+// SigningKey is a secret key for the URL signatures (HMAC-SHA256).
+type SigningKey struct {
+	// Key identifier (is passed in the signed URL, so it must not be secret).
+	ID string
+
+	// Secret key (at least 16 bytes).
+	Secret string
+}
+
+// SignedURLSettings describes signed (expiring) URLs options.
+type SignedURLSettings struct {
+	// Path patterns (relative to the `Settings.BasePath`), that require signed URLs: regular expression (when it
+	// starts with `^`) or glob (`*` matches any characters sequence, including `/`).
+	Paths []string
+
+	// Active signing keys. The first key is used for the URLs signing, all keys are used for the signatures checking
+	// (so keys can be rotated without invalidating already issued URLs).
+	Keys []SigningKey
+
+	// Bind signed URLs to the client IP address.
+	BindClientIP bool
+}
+
+// clone returns deep copy of the signed URLs settings.
+func (s SignedURLSettings) clone() SignedURLSettings {
+	s.Paths = append([]string(nil), s.Paths...)
+	s.Keys = append([]SigningKey(nil), s.Keys...)
+
+	return s
+}
+
+// validate checks signed URLs settings.
+func (s SignedURLSettings) validate(errs *ValidationErrors) {
+	for i, pattern := range s.Paths {
+		if _, err := compilePathPattern(pattern); err != nil {
+			errs.add(fmt.Sprintf("SignedURLs.Paths[%d]", i), "wrong pattern: %s", err)
+		}
+	}
+
+	if len(s.Paths) > 0 && len(s.Keys) == 0 {
+		errs.add("SignedURLs.Keys", "at least one key must be set")
+	}
+
+	ids := make(map[string]struct{}, len(s.Keys))
+
+	for i, key := range s.Keys {
+		field := fmt.Sprintf("SignedURLs.Keys[%d]", i)
+
+		if _, duplicated := ids[key.ID]; duplicated || key.ID == "" {
+			errs.add(field, `key ID "%s" must be non-empty and unique`, key.ID)
+		}
+
+		if len(key.Secret) < minSigningKeySecretLength {
+			errs.add(field, "secret must be at least %d bytes long", minSigningKeySecretLength)
+		}
+
+		ids[key.ID] = struct{}{}
+	}
+}
+
+// urlSigner signs and checks URLs using prepared signed URLs settings.
+type urlSigner struct {
+	paths        []*regexp.Regexp
+	keys         []SigningKey
+	keysByID     map[string]SigningKey
+	bindClientIP bool
+}
+
+// newURLSigner prepares (already validated) signed URLs settings. Nil is returned, when no keys are set.
+func newURLSigner(s SignedURLSettings) *urlSigner {
+	if len(s.Keys) == 0 {
+		return nil
+	}
+
+	signer := &urlSigner{
+		paths:        make([]*regexp.Regexp, 0, len(s.Paths)),
+		keys:         s.Keys,
+		keysByID:     make(map[string]SigningKey, len(s.Keys)),
+		bindClientIP: s.BindClientIP,
+	}
+
+	for _, pattern := range s.Paths {
+		if re, err := compilePathPattern(pattern); err == nil {
+			signer.paths = append(signer.paths, re)
+		}
+	}
+
+	for _, key := range s.Keys {
+		signer.keysByID[key.ID] = key
+	}
+
+	return signer
+}
+
+// isProtected checks that URL path requires signature.
+func (s *urlSigner) isProtected(urlPath string) bool {
+	for _, re := range s.paths {
+		if re.MatchString(urlPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signature calculates URL signature.
+func (s *urlSigner) signature(key SigningKey, urlPath, expires, clientIP string) string {
+	mac := hmac.New(sha256.New, []byte(key.Secret))
+	_, _ = mac.Write([]byte(urlPath + "\n" + expires + "\n" + clientIP))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Signed URL checking errors.
+var (
+	errInvalidSignature = errors.New("invalid URL signature") //nolint:gochecknoglobals
+	errSignatureExpired = errors.New("URL signature expired") //nolint:gochecknoglobals
+)
+
+// verify checks the request URL signature.
+func (s *urlSigner) verify(r *http.Request, now time.Time) error {
+	var (
+		query   = r.URL.Query()
+		expires = query.Get(SignedURLExpiresParam)
+		ip      string
+	)
+
+	key, found := s.keysByID[query.Get(SignedURLKeyParam)]
+	if !found || expires == "" {
+		return errInvalidSignature
+	}
+
+	if s.bindClientIP {
+		ip = clientIP(r)
+	}
+
+	expected := s.signature(key, r.URL.Path, expires, ip)
+
+	if !hmac.Equal([]byte(expected), []byte(query.Get(SignedURLSignatureParam))) {
+		return errInvalidSignature
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errInvalidSignature
+	}
+
+	if now.Unix() > expiresAt {
+		return errSignatureExpired
+	}
+
+	return nil
+}
+
+// clientIP returns the request client IP address (without port).
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+// SignURL returns signed URL for the path (full URL path, including `Settings.BasePath`), that expires after TTL.
+// Signature covers only the URL path, query string of the passed path is preserved.
+func (fs *FileServer) SignURL(urlPath string, ttl time.Duration) (string, error) {
+	return fs.SignURLForIP(urlPath, ttl, "")
+}
+
+// SignURLForIP returns signed URL (see SignURL), that is bound to the client IP address (when
+// `SignedURLSettings.BindClientIP` is enabled).
+func (fs *FileServer) SignURLForIP(urlPath string, ttl time.Duration, ip string) (string, error) {
+	signer := fs.config().signer
+
+	switch {
+	case signer == nil:
+		return "", errors.New("signing keys are not set")
+
+	case ttl <= 0:
+		return "", errors.New("TTL must be positive")
+
+	case signer.bindClientIP && net.ParseIP(ip) == nil:
+		return "", fmt.Errorf(`wrong client IP address "%s"`, ip)
+	}
+
+	if !signer.bindClientIP {
+		ip = ""
+	}
+
+	u, err := url.Parse(urlPath)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(u.Path, "/") {
+		return "", fmt.Errorf(`path "%s" must start with "/"`, u.Path)
+	}
+
+	var (
+		key     = signer.keys[0]
+		expires = strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+		query   = u.Query()
+	)
+
+	query.Set(SignedURLExpiresParam, expires)
+	query.Set(SignedURLKeyParam, key.ID)
+	query.Set(SignedURLSignatureParam, signer.signature(key, u.Path, expires, ip))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// checkSignedURL checks signed URL for the protected paths. Error response is written and false is returned, when the
+// signature is invalid ("403 Forbidden") or expired ("410 Gone").
+func (fs *FileServer) checkSignedURL(w http.ResponseWriter, r *http.Request, cfg *config, urlPath string) bool {
+	if cfg.signer == nil || !cfg.signer.isProtected(urlPath) {
+		return true
+	}
+
+	switch err := cfg.signer.verify(r, time.Now()); err {
+	case nil:
+		return true
+
+	case errSignatureExpired:
+		fs.handleError(w, r, http.StatusGone)
+
+	default:
+		fs.handleError(w, r, http.StatusForbidden)
+	}
+
+	return false
+}
@@ -0,0 +1,197 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileServer_ServeHTTP_SignedURLs(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "private"), 0700))
+
+	for name, content := range map[string]string{
+		"index.html":         "index",
+		"private/report.pdf": "report",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	var (
+		oldKey = SigningKey{ID: "2020-01", Secret: "old-secret-0123456789"}
+		newKey = SigningKey{ID: "2020-02", Secret: "new-secret-0123456789"}
+	)
+
+	settings := Settings{
+		FilesRoot:    tmpDir,
+		BasePath:     "/files",
+		CacheEnabled: true,
+		SignedURLs:   SignedURLSettings{Paths: []string{"/private/*"}, Keys: []SigningKey{oldKey}},
+	}
+
+	fs, err := NewFileServer(settings)
+	assert.NoError(t, err)
+
+	serve := func(uri string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, uri, nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.RemoteAddr = "192.0.2.10:51234"
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	signedByOldKey, err := fs.SignURL("/files/private/report.pdf?download=1", time.Minute)
+	assert.NoError(t, err)
+
+	u, _ := url.Parse(signedByOldKey)
+	assert.Equal(t, "1", u.Query().Get("download"))
+	assert.Equal(t, "2020-01", u.Query().Get(SignedURLKeyParam))
+
+	// key rotation
+	settings.SignedURLs.Keys = []SigningKey{newKey, oldKey}
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	signedByNewKey, err := fs.SignURL("/files/private/report.pdf", time.Minute)
+	assert.NoError(t, err)
+	assert.Contains(t, signedByNewKey, SignedURLKeyParam+"=2020-02")
+
+	tampered := func(uri, param, value string) string {
+		u, _ := url.Parse(uri)
+		q := u.Query()
+		q.Set(param, value)
+		u.RawQuery = q.Encode()
+
+		return u.String()
+	}
+
+	for i := 0; i < 2; i++ { // second iteration uses cache
+		for _, tt := range []struct {
+			uri      string
+			wantCode int
+		}{
+			{uri: "/files/", wantCode: http.StatusOK},
+			{uri: "/files/private/report.pdf", wantCode: http.StatusForbidden},
+			{uri: "/files/private/missing.pdf", wantCode: http.StatusForbidden},
+			{uri: "/files/./private/report.pdf", wantCode: http.StatusForbidden},
+			{uri: "/files/public/../private/report.pdf", wantCode: http.StatusForbidden},
+			{uri: "/files//private/report.pdf", wantCode: http.StatusForbidden},
+			{uri: signedByOldKey, wantCode: http.StatusOK},
+			{uri: signedByNewKey, wantCode: http.StatusOK},
+			{uri: tampered(signedByNewKey, SignedURLSignatureParam, "AAAA"), wantCode: http.StatusForbidden},
+			{uri: tampered(signedByNewKey, SignedURLKeyParam, "unknown"), wantCode: http.StatusForbidden},
+			{uri: tampered(signedByNewKey, SignedURLKeyParam, "2020-01"), wantCode: http.StatusForbidden},
+			{uri: tampered(signedByNewKey, SignedURLExpiresParam, "9999999999"), wantCode: http.StatusForbidden},
+			{uri: "/files/private/other.pdf?" + mustParseURL(signedByNewKey).RawQuery, wantCode: http.StatusForbidden},
+		} {
+			rr := serve(tt.uri)
+			assert.Equal(t, tt.wantCode, rr.Code, tt.uri)
+
+			if tt.wantCode == http.StatusOK && tt.uri != "/files/" {
+				assert.Equal(t, "report", rr.Body.String())
+			}
+		}
+	}
+
+	// expired signature
+	expires := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expired := "/files/private/report.pdf?" + url.Values{
+		SignedURLExpiresParam:   {expires},
+		SignedURLKeyParam:       {newKey.ID},
+		SignedURLSignatureParam: {fs.config().signer.signature(newKey, "/files/private/report.pdf", expires, "")},
+	}.Encode()
+
+	assert.Equal(t, http.StatusGone, serve(expired).Code)
+
+	// old key removal invalidates its URLs
+	settings.SignedURLs.Keys = []SigningKey{newKey}
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	assert.Equal(t, http.StatusForbidden, serve(signedByOldKey).Code)
+	assert.Equal(t, http.StatusOK, serve(signedByNewKey).Code)
+
+	// client IP binding
+	settings.SignedURLs.BindClientIP = true
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	_, err = fs.SignURL("/files/private/report.pdf", time.Minute)
+	assert.Error(t, err, "client IP is required")
+
+	boundToClient, err := fs.SignURLForIP("/files/private/report.pdf", time.Minute, "192.0.2.10")
+	assert.NoError(t, err)
+
+	boundToAnother, err := fs.SignURLForIP("/files/private/report.pdf", time.Minute, "192.0.2.11")
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, serve(boundToClient).Code)
+	assert.Equal(t, http.StatusForbidden, serve(boundToAnother).Code)
+	assert.Equal(t, http.StatusForbidden, serve(signedByNewKey).Code)
+}
+
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return u
+}
+
+func TestFileServer_SignURL_Errors(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	fs, _ := NewFileServer(Settings{FilesRoot: tmpDir})
+
+	_, err := fs.SignURL("/file", time.Minute)
+	assert.Error(t, err, "keys are not set")
+
+	assert.NoError(t, fs.UpdateSettings(Settings{
+		FilesRoot:  tmpDir,
+		SignedURLs: SignedURLSettings{Keys: []SigningKey{{ID: "1", Secret: "0123456789abcdef"}}},
+	}))
+
+	for _, tt := range []struct {
+		path string
+		ttl  time.Duration
+	}{
+		{path: "/file", ttl: 0},
+		{path: "file", ttl: time.Minute},
+		{path: "%zz", ttl: time.Minute},
+	} {
+		_, err := fs.SignURL(tt.path, tt.ttl)
+		assert.Error(t, err, tt.path)
+	}
+}
+
+func TestSettings_Validate_SignedURLs(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{FilesRoot: tmpDir, SignedURLs: SignedURLSettings{Paths: []string{"/private/*", "^/("}}}.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, []string{"SignedURLs.Paths[1]", "SignedURLs.Keys"}, err.(ValidationErrors).Fields())
+
+	err = Settings{FilesRoot: tmpDir, SignedURLs: SignedURLSettings{Keys: []SigningKey{
+		{ID: "1", Secret: "0123456789abcdef"},
+		{ID: "1", Secret: "0123456789abcdef"},
+		{ID: "", Secret: "0123456789abcdef"},
+		{ID: "2", Secret: "short"},
+	}}}.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, []string{"SignedURLs.Keys[1]", "SignedURLs.Keys[2]", "SignedURLs.Keys[3]"},
+		err.(ValidationErrors).Fields())
+}
@@ -0,0 +1,562 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tusVersion           = "1.0.0"
+	tusExtensions        = "creation,expiration,termination"
+	tusContentType       = "application/offset+octet-stream"
+	tusTargetMetadataKey = "path" // `Upload-Metadata` key with the target URL path
+	tusInfoFileExt       = ".json"
+	tusDataFileExt       = ".part"
+	tusUploadIDLength    = 16 // bytes
+	tusCleanupInterval   = time.Minute
+	defaultTusExpiration = time.Hour * 24
+)
+
+var tusUploadIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`) //nolint:gochecknoglobals
+
+// TusSettings describes resumable uploads handler options.
+type TusSettings struct {
+	// URL path prefix, that the handler is mounted under (like `/uploads/`). Is used for the upload URLs building.
+	BasePath string
+
+	// Directory for the partial uploads (must be located outside the FilesRoot directory).
+	StagingDir string
+
+	// Lifetime of the incomplete uploads (since the last upload activity, 24 hours by default).
+	Expiration time.Duration
+}
+
+// validate checks resumable uploads settings.
+func (s TusSettings) validate(filesRoot string) error {
+	var errs ValidationErrors
+
+	if !strings.HasPrefix(s.BasePath, "/") || !isSafeRelativePath(strings.Trim(s.BasePath, "/")) {
+		errs.add("BasePath", `"%s" must start with "/" and must not contain ".." elements`, s.BasePath)
+	}
+
+	if s.StagingDir == "" {
+		errs.add("StagingDir", "must be set")
+	} else if info, err := os.Stat(s.StagingDir); err != nil || !info.IsDir() {
+		errs.add("StagingDir", `"%s" is not directory`, s.StagingDir)
+	} else if isInsideDir(filesRoot, s.StagingDir) {
+		errs.add("StagingDir", "must be located outside the FilesRoot directory")
+	}
+
+	if s.Expiration < 0 {
+		errs.add("Expiration", "must not be negative")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// isInsideDir checks that the path (with resolved symbolic links) is located inside the directory (or equals to it).
+func isInsideDir(dir, p string) bool {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+
+	p, err = filepath.EvalSymlinks(p)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, p)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// TusHandler implements tus.io resumable uploads protocol (core protocol with `creation`, `expiration` and
+// `termination` extensions). Completed uploads are moved into the FilesRoot directory of the file server, using its
+// write mode settings (authentication, writable paths, file size limit and overwrite policy). Target URL path
+// (relative to the file server base path) must be passed in the `path` upload metadata key. Uploads are bound to the
+// authenticated user, that has created them.
+type TusHandler struct {
+	fs       *FileServer
+	settings TusSettings
+
+	mu          sync.Mutex
+	lastCleanup time.Time
+}
+
+// NewTusHandler creates resumable uploads handler for the file server (its write mode must be enabled).
+func NewTusHandler(fs *FileServer, s TusSettings) (*TusHandler, error) {
+	cfg := fs.config()
+
+	if cfg.writer == nil {
+		return nil, errors.New("file server write mode must be enabled")
+	}
+
+	if err := s.validate(cfg.settings.FilesRoot); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(s.BasePath, "/") {
+		s.BasePath += "/"
+	}
+
+	if s.Expiration == 0 {
+		s.Expiration = defaultTusExpiration
+	}
+
+	return &TusHandler{fs: fs, settings: s}, nil
+}
+
+// tusUpload is an upload state, that is stored in the staging directory (upload offset is the data file size).
+type tusUpload struct {
+	Target  string    `json:"target"` // URL path
+	Length  int64     `json:"length"`
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+// paths returns upload info and data file paths.
+func (h *TusHandler) paths(id string) (infoPath, dataPath string) {
+	base := filepath.Join(h.settings.StagingDir, id)
+
+	return base + tusInfoFileExt, base + tusDataFileExt
+}
+
+// load reads upload info.
+func (h *TusHandler) load(id string) (*tusUpload, error) {
+	infoPath, _ := h.paths(id)
+
+	data, err := ioutil.ReadFile(infoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var upload tusUpload
+
+	if err = json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// save writes upload info.
+func (h *TusHandler) save(id string, upload *tusUpload) error {
+	infoPath, _ := h.paths(id)
+
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(infoPath, data, 0600) //nolint:gomnd
+}
+
+// remove removes upload files.
+func (h *TusHandler) remove(id string) error {
+	infoPath, dataPath := h.paths(id)
+
+	if err := os.Remove(dataPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Remove(infoPath)
+}
+
+// RemoveExpired removes expired incomplete uploads from the staging directory (it is called automatically during
+// requests processing, but can be called manually too).
+func (h *TusHandler) RemoveExpired() error {
+	files, err := ioutil.ReadDir(h.settings.StagingDir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), tusInfoFileExt)
+		if !tusUploadIDRegexp.MatchString(id) || file.Name() != id+tusInfoFileExt {
+			continue
+		}
+
+		_, dataPath := h.paths(id)
+
+		if !h.fs.writeLocks.tryLock(dataPath) { // upload is in progress
+			continue
+		}
+
+		if upload, err := h.load(id); err != nil || upload.Expires.Before(now) {
+			_ = h.remove(id)
+		}
+
+		h.fs.writeLocks.unlock(dataPath)
+	}
+
+	return nil
+}
+
+// removeExpiredLazily removes expired uploads, if they were not removed recently.
+func (h *TusHandler) removeExpiredLazily(now time.Time) {
+	h.mu.Lock()
+
+	if now.Sub(h.lastCleanup) < tusCleanupInterval {
+		h.mu.Unlock()
+
+		return
+	}
+
+	h.lastCleanup = now
+	h.mu.Unlock()
+
+	_ = h.RemoveExpired()
+}
+
+// ServeHTTP implements `http.Handler` interface.
+func (h *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := h.fs.config()
+	r = withConfig(r, cfg)
+
+	cfg.setResponseHeaders(w, r)
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+
+		if cfg.writer != nil {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(cfg.writer.maxFileSize, 10))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		h.fs.handleError(w, r, http.StatusPreconditionFailed)
+
+		return
+	}
+
+	if cfg.writer == nil { // write mode was disabled in runtime
+		h.fs.handleError(w, r, http.StatusForbidden)
+
+		return
+	}
+
+	r, authorized := h.fs.checkAuthRule(w, r, cfg.writer.auth)
+	if !authorized {
+		return
+	}
+
+	h.removeExpiredLazily(time.Now())
+
+	if !strings.HasPrefix(r.URL.Path+"/", h.settings.BasePath) {
+		h.fs.handleError(w, r, http.StatusNotFound)
+
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.settings.BasePath), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		h.create(w, r, cfg)
+
+	case id == "":
+		w.Header().Set("Allow", "OPTIONS, POST")
+		h.fs.handleError(w, r, http.StatusMethodNotAllowed)
+
+	case !tusUploadIDRegexp.MatchString(id):
+		h.fs.handleError(w, r, http.StatusNotFound)
+
+	case r.Method == http.MethodHead, r.Method == http.MethodPatch, r.Method == http.MethodDelete:
+		h.serveUpload(w, r, cfg, id)
+
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		h.fs.handleError(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// parseTusMetadata parses `Upload-Metadata` header value (comma-separated key and base64 encoded value pairs).
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+
+	for _, pair := range strings.Split(header, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, " ", 2) //nolint:gomnd
+
+		var value []byte
+
+		if len(parts) == 2 { //nolint:gomnd
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, err
+			}
+
+			value = decoded
+		}
+
+		metadata[parts[0]] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// create creates new upload.
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request, cfg *config) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 { // deferred length is not supported
+		h.fs.handleError(w, r, http.StatusBadRequest)
+
+		return
+	}
+
+	if length > cfg.writer.maxFileSize {
+		h.fs.handleError(w, r, http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil || metadata[tusTargetMetadataKey] == "" {
+		h.fs.handleError(w, r, http.StatusBadRequest)
+
+		return
+	}
+
+	target := path.Clean("/" + metadata[tusTargetMetadataKey])
+
+	filePath, status := cfg.writer.target(cfg, target)
+	if status != 0 {
+		h.fs.handleError(w, r, status)
+
+		return
+	}
+
+	if _, err = os.Stat(filePath); err == nil && !cfg.writer.overwrite {
+		h.fs.handleError(w, r, http.StatusConflict)
+
+		return
+	}
+
+	id := make([]byte, tusUploadIDLength)
+
+	if _, err = rand.Read(id); err != nil {
+		h.fs.handleError(w, r, http.StatusInternalServerError)
+
+		return
+	}
+
+	user, _ := AuthenticatedUser(r)
+	upload := &tusUpload{Target: target, Length: length, User: user, Expires: time.Now().Add(h.settings.Expiration)}
+
+	if status = h.createFiles(hex.EncodeToString(id), upload); status != 0 {
+		h.fs.handleError(w, r, status)
+
+		return
+	}
+
+	w.Header().Set("Location", h.settings.BasePath+hex.EncodeToString(id))
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+
+	if length == 0 {
+		if status = h.complete(cfg, hex.EncodeToString(id), upload); status != 0 {
+			h.fs.handleError(w, r, status)
+
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// createFiles creates upload files in the staging directory. Non-zero HTTP status code is returned on error.
+func (h *TusHandler) createFiles(id string, upload *tusUpload) int {
+	_, dataPath := h.paths(id)
+
+	f, err := os.OpenFile(dataPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600) //nolint:gomnd
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+
+	if err = f.Close(); err == nil {
+		err = h.save(id, upload)
+	}
+
+	if err != nil {
+		_ = h.remove(id)
+
+		return http.StatusInternalServerError
+	}
+
+	return 0
+}
+
+// serveUpload handles existing upload request.
+func (h *TusHandler) serveUpload(w http.ResponseWriter, r *http.Request, cfg *config, id string) {
+	_, dataPath := h.paths(id)
+
+	if !h.fs.writeLocks.tryLock(dataPath) {
+		h.fs.handleError(w, r, http.StatusLocked)
+
+		return
+	}
+
+	defer h.fs.writeLocks.unlock(dataPath)
+
+	upload, err := h.load(id)
+	if err != nil {
+		h.fs.handleError(w, r, http.StatusNotFound)
+
+		return
+	}
+
+	if user, _ := AuthenticatedUser(r); user != upload.User {
+		h.fs.handleError(w, r, http.StatusForbidden)
+
+		return
+	}
+
+	if upload.Expires.Before(time.Now()) {
+		_ = h.remove(id)
+		h.fs.handleError(w, r, http.StatusGone)
+
+		return
+	}
+
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		h.fs.handleError(w, r, http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(info.Size(), 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodPatch:
+		h.patch(w, r, cfg, id, upload, info.Size())
+
+	case http.MethodDelete:
+		if err = h.remove(id); err != nil {
+			h.fs.handleError(w, r, http.StatusInternalServerError)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// patch appends the request body to the upload data (upload is completed, when all data is received).
+func (h *TusHandler) patch(
+	w http.ResponseWriter, r *http.Request, cfg *config, id string, upload *tusUpload, offset int64,
+) {
+	if r.Header.Get("Content-Type") != tusContentType {
+		h.fs.handleError(w, r, http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	if requested, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64); err != nil || requested != offset {
+		h.fs.handleError(w, r, http.StatusConflict)
+
+		return
+	}
+
+	_, dataPath := h.paths(id)
+
+	f, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		h.fs.handleError(w, r, http.StatusInternalServerError)
+
+		return
+	}
+
+	// received data is kept even if the request body is not read completely (upload can be resumed)
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, upload.Length-offset))
+
+	if err = f.Close(); err != nil || (copyErr != nil && n == 0) {
+		h.fs.handleError(w, r, http.StatusInternalServerError)
+
+		return
+	}
+
+	offset += n
+	upload.Expires = time.Now().Add(h.settings.Expiration)
+
+	if offset == upload.Length {
+		if status := h.complete(cfg, id, upload); status != 0 {
+			h.fs.handleError(w, r, status)
+
+			return
+		}
+	} else if err = h.save(id, upload); err != nil {
+		h.fs.handleError(w, r, http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// complete moves completed upload data into the target file (target path is checked again, because settings can be
+// changed since the upload creation). Upload files are removed on success. Non-zero HTTP status code is returned on
+// error.
+func (h *TusHandler) complete(cfg *config, id string, upload *tusUpload) int {
+	filePath, status := cfg.writer.target(cfg, upload.Target)
+	if status != 0 {
+		return status
+	}
+
+	if !h.fs.writeLocks.tryLock(filePath) {
+		return http.StatusLocked
+	}
+
+	defer h.fs.writeLocks.unlock(filePath)
+
+	_, dataPath := h.paths(id)
+
+	if _, err := cfg.writer.moveFile(dataPath, filePath); err != nil {
+		return writeErrorStatus(err)
+	}
+
+	h.fs.invalidateCache(cfg, upload.Target)
+
+	infoPath, _ := h.paths(id)
+	_ = os.Remove(infoPath)
+
+	return 0
+}
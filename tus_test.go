@@ -0,0 +1,193 @@
+package fileserver
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTusHandler_ServeHTTP(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for _, dir := range []string{"root/artifacts", "staging"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, dir), 0700))
+	}
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "root", "artifacts", "app.js"), []byte("v1"), 0600))
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:    filepath.Join(tmpDir, "root"),
+		CacheEnabled: true,
+		Write: WriteSettings{
+			Enabled:       true,
+			Paths:         []string{"/artifacts/*"},
+			MaxFileSize:   16,
+			Authenticator: staticAuthenticator{},
+		},
+	})
+	assert.NoError(t, err)
+
+	h, err := NewTusHandler(fs, TusSettings{BasePath: "/uploads", StagingDir: filepath.Join(tmpDir, "staging")})
+	assert.NoError(t, err)
+
+	serve := func(method, uri, user, body string, header map[string]string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(method, uri, strings.NewReader(body))
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("Tus-Resumable", tusVersion)
+		req.Header.Set("X-User", user)
+
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+
+		h.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	create := func(target, length string) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/uploads/", "ci", "", map[string]string{
+			"Upload-Length":   length,
+			"Upload-Metadata": "filename bmFtZQ==,path " + base64.StdEncoding.EncodeToString([]byte(target)),
+		})
+	}
+
+	patch := func(location, user, offset, body string) *httptest.ResponseRecorder {
+		return serve(http.MethodPatch, location, user, body, map[string]string{
+			"Content-Type":  tusContentType,
+			"Upload-Offset": offset,
+		})
+	}
+
+	rr := serve(http.MethodOptions, "/uploads/", "", "", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, tusExtensions, rr.Header().Get("Tus-Extension"))
+	assert.Equal(t, "16", rr.Header().Get("Tus-Max-Size"))
+
+	rr = serve(http.MethodPost, "/uploads/", "ci", "", map[string]string{"Tus-Resumable": "0.2.2"})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	assert.Equal(t, tusVersion, rr.Header().Get("Tus-Version"))
+
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/uploads/", "", "", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, create("", "4").Code, "target path is required")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, create("/artifacts/big.js", "17").Code)
+	assert.Equal(t, http.StatusForbidden, create("/index.html", "4").Code, "path is not writable")
+	assert.Equal(t, http.StatusConflict, create("/artifacts/app.js", "4").Code, "file exists")
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodHead, "/uploads/missing", "ci", "", nil).Code)
+
+	// chunked upload
+	rr = create("/artifacts/lib.js", "10")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	location := rr.Header().Get("Location")
+	assert.Regexp(t, `^/uploads/[0-9a-f]{32}$`, location)
+	assert.NotEmpty(t, rr.Header().Get("Upload-Expires"))
+
+	rr = patch(location, "ci", "0", "hello")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "5", rr.Header().Get("Upload-Offset"))
+
+	rr = serve(http.MethodHead, location, "ci", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "5", rr.Header().Get("Upload-Offset"))
+	assert.Equal(t, "10", rr.Header().Get("Upload-Length"))
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+
+	assert.Equal(t, http.StatusForbidden, patch(location, "intruder", "5", "world").Code, "upload owner only")
+	assert.Equal(t, http.StatusConflict, patch(location, "ci", "0", "world").Code, "offset mismatch")
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(http.MethodPatch, location, "ci", "world",
+		map[string]string{"Upload-Offset": "5"}).Code)
+
+	_, err = os.Stat(filepath.Join(tmpDir, "root", "artifacts", "lib.js"))
+	assert.True(t, os.IsNotExist(err), "incomplete upload is not visible")
+
+	rr = patch(location, "ci", "5", "world, extra data")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "10", rr.Header().Get("Upload-Offset"))
+
+	content, err := ioutil.ReadFile(filepath.Join(tmpDir, "root", "artifacts", "lib.js"))
+	assert.NoError(t, err)
+	assert.Equal(t, "helloworld", string(content))
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodHead, location, "ci", "", nil).Code, "upload is completed")
+
+	// empty upload
+	assert.Equal(t, http.StatusCreated, create("/artifacts/empty.js", "0").Code)
+
+	_, err = os.Stat(filepath.Join(tmpDir, "root", "artifacts", "empty.js"))
+	assert.NoError(t, err)
+
+	// termination
+	location = create("/artifacts/canceled.js", "4").Header().Get("Location")
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, location, "ci", "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodHead, location, "ci", "", nil).Code)
+
+	files, _ := ioutil.ReadDir(filepath.Join(tmpDir, "staging"))
+	assert.Len(t, files, 0, "staging files are removed")
+
+	// expiration
+	h.settings.Expiration = -time.Second
+	location = create("/artifacts/abandoned.js", "4").Header().Get("Location")
+	assert.Equal(t, http.StatusGone, serve(http.MethodHead, location, "ci", "", nil).Code)
+
+	create("/artifacts/abandoned.js", "4")
+	assert.NoError(t, h.RemoveExpired())
+
+	files, _ = ioutil.ReadDir(filepath.Join(tmpDir, "staging"))
+	assert.Len(t, files, 0, "expired uploads are removed")
+}
+
+func TestNewTusHandler(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "root", "staging"), 0700))
+
+	settings := Settings{
+		FilesRoot: filepath.Join(tmpDir, "root"),
+		Write:     WriteSettings{Enabled: true, Authenticator: staticAuthenticator{}},
+	}
+
+	fs, err := NewFileServer(settings)
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		settings TusSettings
+		fields   []string
+	}{
+		{TusSettings{BasePath: "/uploads/", StagingDir: tmpDir}, nil},
+		{TusSettings{BasePath: "uploads", StagingDir: tmpDir}, []string{"BasePath"}},
+		{TusSettings{BasePath: "/uploads/", StagingDir: filepath.Join(tmpDir, "root", "staging")}, []string{"StagingDir"}},
+		{TusSettings{BasePath: "/uploads/", StagingDir: filepath.Join(tmpDir, "missing")}, []string{"StagingDir"}},
+		{TusSettings{BasePath: "/uploads/", Expiration: -time.Hour}, []string{"StagingDir", "Expiration"}},
+	} {
+		_, err := NewTusHandler(fs, tc.settings)
+
+		if tc.fields == nil {
+			assert.NoError(t, err)
+
+			continue
+		}
+
+		if assert.IsType(t, ValidationErrors{}, err) {
+			assert.Equal(t, tc.fields, err.(ValidationErrors).Fields())
+		}
+	}
+
+	settings.Write.Enabled = false
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	_, err = NewTusHandler(fs, TusSettings{BasePath: "/uploads/", StagingDir: tmpDir})
+	assert.Error(t, err, "write mode must be enabled")
+}
@@ -0,0 +1,246 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	maxCacheMaxFileSize = 1024 * 1024 * 512 // 512 MiB
+	maxCacheMaxItems    = 1024 * 1024
+)
+
+var (
+	errorFileNamesKeyRegexp = regexp.MustCompile(`^[1-5](\d\d|xx)$`)                    //nolint:gochecknoglobals
+	languageTagRegexp       = regexp.MustCompile(`^[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*$`) //nolint:gochecknoglobals
+)
+
+// FieldError describes settings field validation error.
+type FieldError struct {
+	Field   string // field name (like `CacheTTL` or `ErrorFileNames[404]`)
+	Message string
+}
+
+// Error implements error interface.
+func (e *FieldError) Error() string { return e.Field + ": " + e.Message }
+
+// ValidationErrors is an aggregated settings validation error.
+type ValidationErrors []*FieldError
+
+// Error implements error interface.
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Error()
+	}
+
+	return "wrong settings: " + strings.Join(messages, "; ")
+}
+
+// Fields returns names of the fields with errors.
+func (e ValidationErrors) Fields() []string {
+	fields := make([]string, len(e))
+
+	for i, fieldErr := range e {
+		fields[i] = fieldErr.Field
+	}
+
+	return fields
+}
+
+func (e *ValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks settings values (empty values are allowed, defaults will be used for them). All found problems are
+// returned as ValidationErrors.
+func (s Settings) Validate() error { //nolint:funlen,gocognit,gocyclo
+	var errs ValidationErrors
+
+	if s.FilesRoot == "" {
+		errs.add("FilesRoot", "must be set")
+	} else if info, err := os.Stat(s.FilesRoot); err != nil {
+		if os.IsNotExist(err) {
+			errs.add("FilesRoot", `directory "%s" does not exists`, s.FilesRoot)
+		} else {
+			errs.add("FilesRoot", err.Error())
+		}
+	} else if !info.IsDir() {
+		errs.add("FilesRoot", `"%s" is not directory`, s.FilesRoot)
+	}
+
+	for i, l := range s.Layers {
+		if l == nil {
+			errs.add(fmt.Sprintf("Layers[%d]", i), "must not be nil")
+		}
+	}
+
+	if s.BasePath != "" && (!strings.HasPrefix(s.BasePath, "/") || !isSafeRelativePath(s.BasePath[1:])) {
+		errs.add("BasePath", `"%s" must start with "/" and must not contain ".." elements`, s.BasePath)
+	}
+
+	for i, rule := range s.RewriteRules {
+		if _, err := compileRewriteRule(rule); err != nil {
+			errs.add(fmt.Sprintf("RewriteRules[%d]", i), err.Error())
+		}
+	}
+
+	if s.RedirectsFileName != "" && !isSafeRelativePath(s.RedirectsFileName) {
+		errs.add("RedirectsFileName", `"%s" must be relative path without ".." elements`, s.RedirectsFileName)
+	}
+
+	if _, err := canonicalHeaders(s.Headers); err != nil {
+		errs.add("Headers", err.Error())
+	}
+
+	for i, rule := range s.HeaderRules {
+		if _, err := compileHeaderRule(rule); err != nil {
+			errs.add(fmt.Sprintf("HeaderRules[%d]", i), err.Error())
+		}
+	}
+
+	if s.HeadersFileName != "" && !isSafeRelativePath(s.HeadersFileName) {
+		errs.add("HeadersFileName", `"%s" must be relative path without ".." elements`, s.HeadersFileName)
+	}
+
+	s.CORS.validate(&errs)
+	s.SignedURLs.validate(&errs)
+	s.RateLimit.validate(&errs)
+	s.Bandwidth.validate(&errs)
+	s.Ranges.validate(&errs)
+	s.Write.validate(&errs)
+
+	for i, rule := range s.AuthRules {
+		if _, err := compileAuthRule(rule); err != nil {
+			errs.add(fmt.Sprintf("AuthRules[%d]", i), err.Error())
+		}
+	}
+
+	if s.IndexFileName != "" && !isSafeRelativePath(s.IndexFileName) {
+		errs.add("IndexFileName", `"%s" must be relative path without ".." elements`, s.IndexFileName)
+	}
+
+	s.validateErrorFile(&errs, "ErrorFileName", s.ErrorFileName)
+
+	keys := make([]string, 0, len(s.ErrorFileNames))
+
+	for key := range s.ErrorFileNames {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys) // for the errors order stability
+
+	for _, key := range keys {
+		var (
+			name  = s.ErrorFileNames[key]
+			field = fmt.Sprintf("ErrorFileNames[%s]", key)
+		)
+
+		if !errorFileNamesKeyRegexp.MatchString(key) {
+			errs.add(field, "key must be HTTP status code (like 404) or class (like 5xx)")
+		}
+
+		if name == "" {
+			errs.add(field, "file name must be set")
+		} else {
+			s.validateErrorFile(&errs, field, name)
+		}
+	}
+
+	for i, lang := range s.Languages {
+		if !languageTagRegexp.MatchString(lang) {
+			errs.add(fmt.Sprintf("Languages[%d]", i), `"%s" is not valid language tag`, lang)
+		}
+	}
+
+	for i, method := range s.AllowedHTTPMethods {
+		if !isKnownHTTPMethod(method) {
+			errs.add(fmt.Sprintf("AllowedHTTPMethods[%d]", i), `unknown HTTP method "%s"`, method)
+		}
+	}
+
+	if s.CacheTTL < 0 {
+		errs.add("CacheTTL", "must not be negative")
+	}
+
+	if s.CacheMaxFileSize < 0 || s.CacheMaxFileSize > maxCacheMaxFileSize {
+		errs.add("CacheMaxFileSize", "must be between 0 and %d", maxCacheMaxFileSize)
+	}
+
+	if s.CacheRevalidateInterval < 0 {
+		errs.add("CacheRevalidateInterval", "must not be negative")
+	}
+
+	if s.CacheStaleTTL < 0 {
+		errs.add("CacheStaleTTL", "must not be negative")
+	}
+
+	if s.CacheMmapMaxFileSize < 0 {
+		errs.add("CacheMmapMaxFileSize", "must not be negative")
+	}
+
+	if s.CacheMaxItems > maxCacheMaxItems {
+		errs.add("CacheMaxItems", "must not be greater than %d", maxCacheMaxItems)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validateErrorFile checks error page template file name (and file existence in strict mode).
+func (s Settings) validateErrorFile(errs *ValidationErrors, field, name string) {
+	if name == "" {
+		return
+	}
+
+	if !isSafeRelativePath(name) {
+		errs.add(field, `"%s" must be relative path without ".." elements`, name)
+
+		return
+	}
+
+	if s.StrictValidation && s.FilesRoot != "" {
+		info, err := os.Stat(filepath.Join(s.FilesRoot, filepath.FromSlash(name)))
+
+		if err != nil || !info.Mode().IsRegular() {
+			errs.add(field, `file "%s" does not exists`, name)
+		}
+	}
+}
+
+// isSafeRelativePath checks that path is relative and does not contain `..` elements.
+func isSafeRelativePath(p string) bool {
+	p = filepath.ToSlash(p)
+
+	if path.IsAbs(p) || filepath.IsAbs(p) {
+		return false
+	}
+
+	for _, element := range strings.Split(p, "/") {
+		if element == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isKnownHTTPMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettings_Validate(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "error.html"), []byte("error"), 0600))
+
+	for _, tt := range []struct {
+		name         string
+		giveSettings Settings
+		wantFields   []string
+	}{
+		{
+			name:         "empty settings (defaults)",
+			giveSettings: Settings{FilesRoot: tmpDir},
+		},
+		{
+			name: "valid settings",
+			giveSettings: Settings{
+				FilesRoot:          tmpDir,
+				BasePath:           "/static/",
+				IndexFileName:      "sub/index.html",
+				ErrorFileName:      "error.html",
+				ErrorFileNames:     map[string]string{"404": "404.html", "5xx": "5xx.html"},
+				Languages:          []string{"en", "pt-BR"},
+				AllowedHTTPMethods: []string{http.MethodGet, http.MethodHead, http.MethodOptions},
+				CacheTTL:           time.Minute,
+				CacheMaxFileSize:   1024,
+				CacheMaxItems:      1024,
+			},
+		},
+		{
+			name:         "missing files root",
+			giveSettings: Settings{},
+			wantFields:   []string{"FilesRoot"},
+		},
+		{
+			name: "multiple errors",
+			giveSettings: Settings{
+				FilesRoot:          filepath.Join(tmpDir, "missing"),
+				BasePath:           "static",
+				IndexFileName:      "../index.html",
+				ErrorFileName:      "/etc/passwd",
+				ErrorFileNames:     map[string]string{"4xy": "4xx.html"},
+				Languages:          []string{"en_US"},
+				AllowedHTTPMethods: []string{http.MethodGet, "FOO"},
+				CacheTTL:           -time.Second,
+				CacheMaxFileSize:   -1,
+				CacheMaxItems:      maxCacheMaxItems + 1,
+			},
+			wantFields: []string{
+				"FilesRoot", "BasePath", "IndexFileName", "ErrorFileName", "ErrorFileNames[4xy]", "Languages[0]",
+				"AllowedHTTPMethods[1]", "CacheTTL", "CacheMaxFileSize", "CacheMaxItems",
+			},
+		},
+		{
+			name: "too big cache file size",
+			giveSettings: Settings{
+				FilesRoot:        tmpDir,
+				CacheMaxFileSize: maxCacheMaxFileSize + 1,
+				ErrorFileNames:   map[string]string{"404": "foo/../../404.html", "500": ""},
+			},
+			wantFields: []string{"ErrorFileNames[404]", "ErrorFileNames[500]", "CacheMaxFileSize"},
+		},
+		{
+			name: "strict mode with existing error file",
+			giveSettings: Settings{
+				FilesRoot:        tmpDir,
+				ErrorFileName:    "error.html",
+				StrictValidation: true,
+			},
+		},
+		{
+			name: "strict mode with missing error files",
+			giveSettings: Settings{
+				FilesRoot:        tmpDir,
+				ErrorFileName:    "missing.html",
+				ErrorFileNames:   map[string]string{"404": "error.html", "5xx": "5xx.html"},
+				StrictValidation: true,
+			},
+			wantFields: []string{"ErrorFileName", "ErrorFileNames[5xx]"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.giveSettings.Validate()
+
+			if len(tt.wantFields) == 0 {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			assert.Error(t, err)
+
+			if errs, ok := err.(ValidationErrors); assert.True(t, ok) {
+				assert.ElementsMatch(t, tt.wantFields, errs.Fields())
+
+				for _, field := range tt.wantFields {
+					assert.Contains(t, errs.Error(), field+": ")
+				}
+			}
+		})
+	}
+}
+
+func TestNewFileServer_ValidationError(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	fs, err := NewFileServer(Settings{FilesRoot: tmpDir, CacheTTL: -1, AllowedHTTPMethods: []string{"get"}})
+
+	assert.Nil(t, fs)
+	assert.EqualError(t, err, `wrong settings: AllowedHTTPMethods[0]: unknown HTTP method "get"; CacheTTL: must not be negative`)
+}
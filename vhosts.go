@@ -0,0 +1,141 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// VirtualHosts is a multi-site handler (implements `http.Handler` interface), that selects FileServer by the request
+// `Host` header. Every site is served by its own FileServer instance (with its own settings and cache namespace).
+type VirtualHosts struct {
+	// HTTP status code for the requests with unknown host (is used when default server is not set).
+	UnknownHostErrorCode int
+
+	// Server, which error handlers stack is used for the unknown host errors responding.
+	ErrorServer *FileServer
+
+	mu        sync.RWMutex
+	exact     map[string]*FileServer
+	wildcards []wildcardHost // ordered by specificity (longest suffix first)
+	fallback  *FileServer    // nil, if default server is not set
+}
+
+// wildcardHost describes wildcard host pattern (like `*.example.com`).
+type wildcardHost struct {
+	suffix string // eg.: `.example.com`
+	fs     *FileServer
+}
+
+// NewVirtualHosts creates multi-site handler without any sites.
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{
+		UnknownHostErrorCode: http.StatusNotFound,
+		ErrorServer:          newFileServer(),
+		exact:                make(map[string]*FileServer),
+	}
+}
+
+// Add registers file server for the host pattern. Pattern can be an exact host name (`example.com`), wildcard
+// subdomains pattern (`*.example.com`, matches subdomains of any level, but not `example.com` itself) or `*` for the
+// default server (it is used for the unknown hosts). Previously registered server for the same pattern is replaced.
+func (v *VirtualHosts) Add(pattern string, fs *FileServer) error {
+	if fs == nil {
+		return fmt.Errorf(`file server for the pattern "%s" is not set`, pattern)
+	}
+
+	isDefault := strings.TrimSpace(pattern) == "*"
+	pattern = normalizeHost(pattern)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	switch {
+	case isDefault:
+		v.fallback = fs
+
+	case strings.HasPrefix(pattern, "*."):
+		suffix := pattern[1:]
+
+		if strings.Contains(suffix[1:], "*") || len(suffix) < 2 { //nolint:gomnd
+			return fmt.Errorf(`wrong wildcard host pattern "%s"`, pattern)
+		}
+
+		for i, w := range v.wildcards {
+			if w.suffix == suffix {
+				v.wildcards[i].fs = fs
+
+				return nil
+			}
+		}
+
+		v.wildcards = append(v.wildcards, wildcardHost{suffix: suffix, fs: fs})
+
+		sort.SliceStable(v.wildcards, func(i, j int) bool {
+			return len(v.wildcards[i].suffix) > len(v.wildcards[j].suffix)
+		})
+
+	case pattern == "" || strings.Contains(pattern, "*"):
+		return fmt.Errorf(`wrong host pattern "%s"`, pattern)
+
+	default:
+		v.exact[pattern] = fs
+	}
+
+	return nil
+}
+
+// Lookup returns file server for the host (port is ignored). Default server is returned for unknown hosts, if it is
+// set.
+func (v *VirtualHosts) Lookup(host string) (*FileServer, bool) {
+	host = normalizeHost(host)
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if fs, ok := v.exact[host]; ok {
+		return fs, true
+	}
+
+	for _, w := range v.wildcards {
+		if len(host) > len(w.suffix) && strings.HasSuffix(host, w.suffix) {
+			return w.fs, true
+		}
+	}
+
+	if v.fallback != nil {
+		return v.fallback, true
+	}
+
+	return nil, false
+}
+
+// ServeHTTP responds to an HTTP request using file server for the request host.
+func (v *VirtualHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fs, ok := v.Lookup(r.Host); ok {
+		fs.ServeHTTP(w, r)
+
+		return
+	}
+
+	errorServer := v.ErrorServer
+	if errorServer == nil {
+		errorServer = newFileServer()
+	}
+
+	errorServer.handleError(w, r, v.UnknownHostErrorCode)
+}
+
+// normalizeHost converts host (or host pattern) into lower case and removes port and trailing dot.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(host, ".")
+}
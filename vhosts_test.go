@@ -0,0 +1,114 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/avto-dev/go-simple-fileserver/cache"
+)
+
+func TestVirtualHosts_ServeHTTP(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	var (
+		vh     = NewVirtualHosts()
+		shared = cache.NewInMemoryCache(0)
+	)
+
+	for _, site := range []string{"exact", "wildcard", "deep", "default"} {
+		root := filepath.Join(tmpDir, site)
+		assert.NoError(t, os.Mkdir(root, 0700))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "index.html"), []byte(site), 0600))
+	}
+
+	newServer := func(site string) *FileServer {
+		fs, err := NewFileServer(Settings{
+			FilesRoot:      filepath.Join(tmpDir, site),
+			CacheEnabled:   true,
+			CacheNamespace: site + ":",
+		})
+		assert.NoError(t, err)
+
+		fs.Cache = shared
+
+		return fs
+	}
+
+	serve := func(host string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(http.MethodGet, "/", nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Host = host
+		vh.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	assert.NoError(t, vh.Add("Example.COM", newServer("exact")))
+	assert.NoError(t, vh.Add("*.example.com", newServer("wildcard")))
+	assert.NoError(t, vh.Add("*.deep.example.com", newServer("deep")))
+
+	for _, pattern := range []string{"", "foo.*.com", "*.", "*.*.com"} {
+		assert.Error(t, vh.Add(pattern, newServer("exact")), pattern)
+	}
+
+	assert.Error(t, vh.Add("foo.com", nil))
+
+	for host, expected := range map[string]string{
+		"example.com":           "exact",
+		"EXAMPLE.com:8080":      "exact",
+		"example.com.":          "exact",
+		"www.example.com":       "wildcard",
+		"a.b.example.com":       "wildcard",
+		"deep.example.com":      "wildcard",
+		"www.deep.example.com":  "deep",
+		"www.deep.example.com.": "deep",
+	} {
+		rr := serve(host)
+
+		assert.Equal(t, http.StatusOK, rr.Code, host)
+		assert.Equal(t, expected, rr.Body.String(), host)
+	}
+
+	assert.Equal(t, uint32(3), shared.Count())
+
+	_, cacheHit := shared.Get("exact:" + filepath.Join(tmpDir, "exact", "index.html")) // cache keys are namespaced
+	assert.True(t, cacheHit)
+
+	// unknown host
+	rr := serve("example.org")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Not Found")
+
+	vh.UnknownHostErrorCode = http.StatusMisdirectedRequest
+	vh.ErrorServer.ErrorHandlers = []ErrorHandlerFunc{JSONErrorRenderer()}
+
+	rr = serve("example.org")
+	assert.Equal(t, http.StatusMisdirectedRequest, rr.Code)
+	assert.JSONEq(t, `{"code":421,"message":"Misdirected Request"}`, rr.Body.String())
+
+	vh.ErrorServer = nil
+
+	rr = serve("example.org")
+	assert.Equal(t, http.StatusMisdirectedRequest, rr.Code)
+
+	// default server
+	assert.NoError(t, vh.Add("*", newServer("default")))
+
+	rr = serve("example.org")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "default", rr.Body.String())
+
+	fs, ok := vh.Lookup("")
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(tmpDir, "default"), fs.CurrentSettings().FilesRoot)
+}
@@ -0,0 +1,62 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const watchedFileCheckInterval = time.Second
+
+// watchedFile is a (optional) configuration file, that is parsed on the first access and reloaded after changing.
+// File modification is checked not often than once per check interval. Previous content is used, when the file
+// becomes unparsable.
+type watchedFile struct {
+	path          string
+	parse         func(data []byte) (interface{}, error)
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	modTime   time.Time
+	size      int64
+	value     interface{} // nil, if file does not exist
+}
+
+func newWatchedFile(path string, parse func([]byte) (interface{}, error)) *watchedFile {
+	return &watchedFile{path: path, parse: parse, checkInterval: watchedFileCheckInterval}
+}
+
+// Value returns parsed file content (nil, if file does not exist).
+func (f *watchedFile) Value() interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+
+	if !f.checkedAt.IsZero() && now.Sub(f.checkedAt) < f.checkInterval {
+		return f.value
+	}
+
+	f.checkedAt = now
+
+	info, err := os.Stat(f.path)
+	if err != nil || !info.Mode().IsRegular() {
+		f.value, f.modTime, f.size = nil, time.Time{}, 0
+
+		return nil
+	}
+
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size && f.value != nil {
+		return f.value
+	}
+
+	if data, err := ioutil.ReadFile(f.path); err == nil {
+		if value, err := f.parse(data); err == nil {
+			f.value, f.modTime, f.size = value, info.ModTime(), info.Size()
+		}
+	}
+
+	return f.value
+}
@@ -0,0 +1,673 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// WebDAV methods (RFC 4918).
+const (
+	methodPropfind  = "PROPFIND"
+	methodProppatch = "PROPPATCH"
+	methodCopy      = "COPY"
+	methodMove      = "MOVE"
+	methodLock      = "LOCK"
+	methodUnlock    = "UNLOCK"
+)
+
+// WebDAVSettings describes WebDAV mode options. WebDAV resources are resolved in the same way, as files for the
+// `GET` requests (layers are merged, service files are hidden, `GET` and `HEAD` requests are served as usual, using
+// the cache), read requests are checked using the AuthRules and signed URLs settings (for all the listed resources).
+// WebDAV is read-only (`PROPFIND` only), unless write mode is enabled (`Settings.Write`): then `PROPPATCH`, `MKCOL`,
+// `COPY`, `MOVE`, `LOCK`, `UNLOCK`, `PUT` and `DELETE` (when `WriteSettings.Delete` is enabled) requests are handled by
+// WebDAV (with RFC 4918 semantics, like directories copying), using write mode authentication, paths, limits and
+// policies (only files and empty directories can be removed). Dead properties (`PROPPATCH`) are not stored.
+type WebDAVSettings struct {
+	// Enables WebDAV mode (required methods are allowed automatically).
+	Enabled bool
+
+	// Disables WebDAV write methods even in write mode (`PUT`, `POST` and `DELETE` requests are handled by the write
+	// mode in this case).
+	ReadOnly bool
+}
+
+// davConfig contains prepared WebDAV settings.
+type davConfig struct {
+	methods  []string // handled HTTP methods
+	writable bool
+}
+
+// newDAVConfig prepares WebDAV settings. Nil is returned, when WebDAV is disabled.
+func newDAVConfig(s Settings) *davConfig {
+	if !s.WebDAV.Enabled {
+		return nil
+	}
+
+	dav := &davConfig{methods: []string{methodPropfind}}
+
+	if s.Write.Enabled && !s.WebDAV.ReadOnly {
+		dav.writable = true
+		dav.methods = append(dav.methods, methodProppatch, methodMkcol, methodCopy, methodMove, methodLock,
+			methodUnlock, http.MethodPut)
+
+		if s.Write.Delete {
+			dav.methods = append(dav.methods, http.MethodDelete)
+		}
+	}
+
+	return dav
+}
+
+// handles checks that HTTP method is handled by WebDAV (false is returned for the nil config).
+func (dav *davConfig) handles(method string) bool {
+	if dav == nil {
+		return false
+	}
+
+	for _, m := range dav.methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compliance returns `DAV` header value (compliance classes) for the `OPTIONS` responses.
+func (dav *davConfig) compliance() string {
+	if dav.writable {
+		return "1, 2" // locking is supported
+	}
+
+	return "1"
+}
+
+// davLockSystem returns WebDAV locks storage (it is shared between config snapshots).
+func (fs *FileServer) davLockSystem() webdav.LockSystem {
+	fs.davLocksOnce.Do(func() { fs.davLocks = webdav.NewMemLS() })
+
+	return fs.davLocks
+}
+
+// davContentLengthKey is a request context key for the expected `PUT` request body length.
+type davContentLengthKey struct{}
+
+// davDeleteKey is a request context key, that marks `DELETE` requests (non-empty directories are not removed).
+type davDeleteKey struct{}
+
+// serveWebDAV handles WebDAV request for the URL path (relative to the base path).
+func (fs *FileServer) serveWebDAV(w http.ResponseWriter, r *http.Request, cfg *config, urlPath string) {
+	urlPath = path.Clean(urlPath)
+
+	if cfg.isHiddenPath(urlPath) {
+		fs.handleError(w, r, http.StatusNotFound)
+
+		return
+	}
+
+	var authorized bool
+
+	if r.Method == methodPropfind {
+		if depth := r.Header.Get("Depth"); depth == "" || strings.EqualFold(depth, "infinity") {
+			fs.handleError(w, r, http.StatusForbidden) // RFC 4918, 9.1: "propfind-finite-depth" precondition
+
+			return
+		}
+
+		for _, p := range davReadPaths(cfg, urlPath, r.Header.Get("Depth")) {
+			if r, authorized = fs.checkAccess(w, r, cfg, p); !authorized {
+				return
+			}
+		}
+	} else {
+		if r, authorized = fs.checkAuthRule(w, r, cfg.writer.auth); !authorized {
+			return
+		}
+
+		if r.Method == methodCopy || r.Method == methodMove { // source tree is read (from all layers)
+			for _, p := range davReadPaths(cfg, urlPath, "infinity") {
+				if r, authorized = fs.checkAccess(w, r, cfg, p); !authorized {
+					return
+				}
+			}
+		}
+
+		if status := fs.checkDAVWrite(r, cfg, urlPath); status != 0 {
+			fs.handleError(w, r, status)
+
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			r = r.WithContext(context.WithValue(r.Context(), davContentLengthKey{}, r.ContentLength))
+
+		case http.MethodDelete:
+			r = r.WithContext(context.WithValue(r.Context(), davDeleteKey{}, true))
+		}
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     cfg.settings.BasePath,
+		FileSystem: &davFileSystem{fs: fs, cfg: cfg},
+		LockSystem: fs.davLockSystem(),
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// davReadPaths returns URL paths, that are read by the request with passed depth (`PROPFIND`, or `infinity` for the
+// `COPY` and `MOVE` sources): the resource path (collection is checked with and without the trailing slash) and paths
+// of the collection members (direct members for the `Depth: 1`, members of the whole tree for the `infinity`).
+// Collections are merged from all layers.
+func davReadPaths(cfg *config, urlPath, depth string) []string {
+	paths := []string{urlPath}
+
+	if urlPath != "/" {
+		paths = append(paths, urlPath+"/")
+	}
+
+	if depth != "1" && depth != "infinity" {
+		return paths
+	}
+
+	dir, err := (&davFileSystem{cfg: cfg}).open(urlPath)
+	if err != nil {
+		return paths // error is responded by the WebDAV handler
+	}
+
+	defer dir.Close()
+
+	if info, err := dir.Stat(); err != nil || !info.IsDir() {
+		return paths
+	}
+
+	entries, _ := dir.Readdir(0)
+
+	for _, entry := range entries {
+		member := path.Join(urlPath, entry.Name())
+
+		switch {
+		case entry.IsDir() && depth == "infinity":
+			paths = append(paths, davReadPaths(cfg, member, depth)...)
+
+		case entry.IsDir():
+			paths = append(paths, member, member+"/")
+
+		default:
+			paths = append(paths, member)
+		}
+	}
+
+	return paths
+}
+
+// checkDAVWrite checks WebDAV write request paths (nested paths are checked by the davFileSystem). Non-zero HTTP status
+// code is returned, when the request is not allowed.
+func (fs *FileServer) checkDAVWrite(r *http.Request, cfg *config, urlPath string) int {
+	wr := cfg.writer
+
+	if r.Method != methodCopy && r.Method != methodUnlock {
+		if _, status := wr.target(cfg, urlPath); status != 0 {
+			return status
+		}
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		if filePath, _ := wr.target(cfg, urlPath); isNonEmptyDir(filePath) {
+			return http.StatusConflict
+		}
+
+	case methodCopy, methodMove:
+		u, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil || (u.Host != "" && u.Host != r.Host) {
+			return http.StatusBadGateway
+		}
+
+		dst := u.Path
+		if basePath := cfg.settings.BasePath; basePath != "" {
+			if !strings.HasPrefix(dst, basePath+"/") {
+				return http.StatusBadGateway
+			}
+
+			dst = dst[len(basePath):]
+		}
+
+		dst = path.Clean("/" + dst)
+
+		dstPath, status := wr.target(cfg, dst)
+		if status != 0 {
+			return status
+		}
+
+		if _, err := os.Lstat(dstPath); err == nil && !wr.overwrite { // destination would be replaced
+			return http.StatusPreconditionFailed
+		}
+
+		return davTreeStatus(cfg, urlPath, dst, r.Method == methodMove)
+
+	case http.MethodPut:
+		if r.ContentLength > wr.maxFileSize {
+			return http.StatusRequestEntityTooLarge
+		}
+
+		if filePath, _ := wr.target(cfg, urlPath); !wr.overwrite {
+			if _, err := os.Stat(filePath); err == nil {
+				return http.StatusConflict
+			}
+		}
+	}
+
+	return 0
+}
+
+// isNonEmptyDir checks that the path points to the directory, that contains any entries.
+func isNonEmptyDir(dirPath string) bool {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return false
+	}
+
+	defer dir.Close()
+
+	names, _ := dir.Readdirnames(1)
+
+	return len(names) > 0
+}
+
+// davTreeStatus checks, that all nested paths of the source FilesRoot directory tree (when checkSrc is true) and
+// corresponding destination paths (when destination is not empty) are writable. Non-zero HTTP status code is returned
+// otherwise.
+func davTreeStatus(cfg *config, src, dst string, checkSrc bool) int {
+	filePath := filepath.Join(cfg.writer.root, filepath.FromSlash(src))
+
+	err := walkTree(filePath, func(rel string) error {
+		if checkSrc {
+			if _, status := cfg.writer.target(cfg, path.Join(src, rel)); status != 0 {
+				return os.ErrPermission
+			}
+		}
+
+		if dst != "" {
+			if _, status := cfg.writer.target(cfg, path.Join(dst, rel)); status != 0 {
+				return os.ErrPermission
+			}
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, os.ErrPermission) {
+		return http.StatusForbidden
+	}
+
+	return 0 // missing paths are processed by the WebDAV handler
+}
+
+// davFileSystem implements webdav.FileSystem interface. Files are read from the layers (directories are merged), and
+// written into the FilesRoot directory (atomically, with write mode checks and cache invalidation).
+type davFileSystem struct {
+	fs  *FileServer
+	cfg *config
+}
+
+// writable returns file system path for the writable URL path (os.ErrPermission is returned otherwise).
+func (d *davFileSystem) writable(urlPath string) (string, error) {
+	if !d.cfg.dav.writable {
+		return "", os.ErrPermission
+	}
+
+	filePath, status := d.cfg.writer.target(d.cfg, path.Clean("/"+urlPath))
+	if status != 0 {
+		return "", os.ErrPermission
+	}
+
+	return filePath, nil
+}
+
+// walkTree calls the function for the URL paths (relative to the passed one) of all entries in the directory tree
+// (including the tree root).
+func walkTree(filePath string, fn func(rel string) error) error {
+	return filepath.Walk(filePath, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(filePath, p)
+		if err != nil {
+			return err
+		}
+
+		return fn(path.Clean("/" + filepath.ToSlash(rel)))
+	})
+}
+
+// Mkdir implements webdav.FileSystem interface.
+func (d *davFileSystem) Mkdir(_ context.Context, name string, _ os.FileMode) error {
+	dirPath, err := d.writable(name)
+	if err != nil {
+		return err
+	}
+
+	if !d.fs.writeLocks.tryLock(dirPath) {
+		return errPathLocked
+	}
+
+	defer d.fs.writeLocks.unlock(dirPath)
+
+	return os.Mkdir(dirPath, createdDirMode)
+}
+
+// OpenFile implements webdav.FileSystem interface.
+func (d *davFileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	name = path.Clean("/" + name)
+
+	if flag&(os.O_CREATE|os.O_TRUNC) == 0 { // existing files are opened for the dead properties patching only
+		return d.open(name)
+	}
+
+	filePath, err := d.writable(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !d.fs.writeLocks.tryLock(filePath) {
+		return nil, errPathLocked
+	}
+
+	f, _, err := d.cfg.writer.createFile(filePath, false)
+	if err != nil {
+		d.fs.writeLocks.unlock(filePath)
+
+		return nil, err
+	}
+
+	return &davWrittenFile{atomicFile: f, ctx: ctx, fs: d.fs, cfg: d.cfg, urlPath: name}, nil
+}
+
+// open opens the file (or directory) for reading.
+func (d *davFileSystem) open(name string) (webdav.File, error) {
+	if d.cfg.isHiddenPath(name) {
+		return nil, os.ErrNotExist
+	}
+
+	var dir *davMergedDir
+
+	for _, l := range d.cfg.layers {
+		f, err := l.fs.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+
+			return nil, err
+		}
+
+		switch {
+		case dir != nil && info.IsDir():
+			dir.others = append(dir.others, f)
+
+		case dir != nil: // file in the lower layer is overridden by the directory
+			_ = f.Close()
+
+		case info.IsDir():
+			dir = &davMergedDir{File: f, cfg: d.cfg, name: name}
+
+		default:
+			return davReadOnlyFile{f}, nil
+		}
+	}
+
+	if dir == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return dir, nil
+}
+
+// RemoveAll implements webdav.FileSystem interface. Directory tree is removed, when it is replaced (by the `COPY` or
+// `MOVE` requests), only files and empty directories are removed by the `DELETE` requests.
+func (d *davFileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = path.Clean("/" + name)
+
+	filePath, err := d.writable(name)
+	if err != nil {
+		return err
+	}
+
+	if !d.fs.writeLocks.tryLock(filePath) {
+		return errPathLocked
+	}
+
+	defer d.fs.writeLocks.unlock(filePath)
+
+	var removed []string
+
+	if err = walkTree(filePath, func(rel string) error {
+		if _, err := d.writable(path.Join(name, rel)); err != nil {
+			return err
+		}
+
+		removed = append(removed, path.Join(name, rel))
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, urlPath := range removed {
+			d.fs.invalidateCache(d.cfg, urlPath)
+		}
+	}()
+
+	if deletion, _ := ctx.Value(davDeleteKey{}).(bool); deletion {
+		return os.Remove(filePath)
+	}
+
+	return os.RemoveAll(filePath)
+}
+
+// Rename implements webdav.FileSystem interface.
+func (d *davFileSystem) Rename(_ context.Context, oldName, newName string) error {
+	oldName, newName = path.Clean("/"+oldName), path.Clean("/"+newName)
+
+	oldPath, err := d.writable(oldName)
+	if err != nil {
+		return err
+	}
+
+	newPath, err := d.writable(newName)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{oldPath, newPath} {
+		if !d.fs.writeLocks.tryLock(p) {
+			return errPathLocked
+		}
+
+		defer d.fs.writeLocks.unlock(p)
+	}
+
+	var moved []string
+
+	if err = walkTree(oldPath, func(rel string) error {
+		for _, name := range []string{path.Join(oldName, rel), path.Join(newName, rel)} {
+			if _, err := d.writable(name); err != nil {
+				return err
+			}
+
+			moved = append(moved, name)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, urlPath := range moved {
+			d.fs.invalidateCache(d.cfg, urlPath)
+		}
+	}()
+
+	return os.Rename(oldPath, newPath)
+}
+
+// Stat implements webdav.FileSystem interface.
+func (d *davFileSystem) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	f, err := d.open(path.Clean("/" + name))
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// davReadOnlyFile is a webdav.File, that can not be written.
+type davReadOnlyFile struct {
+	http.File
+}
+
+// Write implements io.Writer interface (os.ErrPermission is always returned).
+func (davReadOnlyFile) Write([]byte) (int, error) { return 0, os.ErrPermission }
+
+// davMergedDir is a read-only directory, that contains entries of the same directories from all layers (entries from
+// the upper layers win). Service files are not listed.
+type davMergedDir struct {
+	http.File             // directory from the upper layer
+	others    []http.File // directories from the lower layers
+	cfg       *config
+	name      string
+	entries   []os.FileInfo // nil, until the first Readdir call
+	offset    int
+}
+
+// Write implements io.Writer interface (os.ErrPermission is always returned).
+func (*davMergedDir) Write([]byte) (int, error) { return 0, os.ErrPermission }
+
+// Close closes directories from all layers.
+func (d *davMergedDir) Close() error {
+	for _, f := range d.others {
+		_ = f.Close()
+	}
+
+	return d.File.Close()
+}
+
+// Readdir implements http.File interface.
+func (d *davMergedDir) Readdir(count int) ([]os.FileInfo, error) {
+	if d.entries == nil {
+		d.entries = make([]os.FileInfo, 0)
+		seen := make(map[string]struct{})
+
+		for _, f := range append([]http.File{d.File}, d.others...) {
+			entries, err := f.Readdir(0)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if _, found := seen[entry.Name()]; found || d.cfg.isHiddenPath(path.Join(d.name, entry.Name())) {
+					continue
+				}
+
+				seen[entry.Name()] = struct{}{}
+				d.entries = append(d.entries, entry)
+			}
+		}
+
+		sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	}
+
+	rest := d.entries[d.offset:]
+
+	if count <= 0 {
+		d.offset = len(d.entries)
+
+		return rest, nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(rest) {
+		count = len(rest)
+	}
+
+	d.offset += count
+
+	return rest[:count], nil
+}
+
+// davWrittenFile is a webdav.File, that is written atomically (target file is replaced on close, when the file was
+// written completely).
+type davWrittenFile struct {
+	*atomicFile
+	ctx     context.Context
+	fs      *FileServer
+	cfg     *config
+	urlPath string
+}
+
+// Read implements io.Reader interface.
+func (f *davWrittenFile) Read(b []byte) (int, error) { return f.file.Read(b) }
+
+// Seek implements io.Seeker interface.
+func (f *davWrittenFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+// Readdir implements http.File interface (files have no entries).
+func (f *davWrittenFile) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+
+// Stat implements http.File interface.
+func (f *davWrittenFile) Stat() (os.FileInfo, error) { return f.file.Stat() }
+
+// Close commits written file. Nothing is committed, when the request was canceled or request body was not read
+// completely.
+func (f *davWrittenFile) Close() error {
+	defer f.fs.writeLocks.unlock(f.target)
+
+	expected, ok := f.ctx.Value(davContentLengthKey{}).(int64)
+
+	if err := f.ctx.Err(); err != nil || (ok && expected >= 0 && expected != f.size) {
+		f.discard()
+
+		if err == nil {
+			err = errors.New("request body was not read completely")
+		}
+
+		return err
+	}
+
+	if err := f.commit(); err != nil {
+		return err
+	}
+
+	f.fs.invalidateCache(f.cfg, f.urlPath)
+
+	return nil
+}
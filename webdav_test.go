@@ -0,0 +1,325 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const propfindAllProps = `<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><allprop/></propfind>`
+
+func TestFileServer_ServeHTTP_WebDAVReadOnly(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for _, dir := range []string{"root/assets", "root/docs/private", "root/docs/signed", "overlay/assets"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, dir), 0700))
+	}
+
+	for name, content := range map[string]string{
+		"root/_headers":            "",
+		"root/assets/logo.svg":     "root logo",
+		"root/assets/icon.svg":     "icon",
+		"overlay/assets/logo.svg":  "overlay logo",
+		"overlay/assets/font.woff": "font",
+		"root/docs/private/a.txt":  "private",
+		"root/docs/signed/b.txt":   "signed",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot:       filepath.Join(tmpDir, "root"),
+		Layers:          []http.FileSystem{http.Dir(filepath.Join(tmpDir, "overlay"))},
+		BasePath:        "/dav",
+		HeadersFileName: "_headers",
+		AuthRules: []AuthRule{
+			{Path: "/private/*", Authenticator: staticAuthenticator{}},
+			{Path: "/docs/private/*", Authenticator: staticAuthenticator{}},
+		},
+		SignedURLs: SignedURLSettings{
+			Paths: []string{"/docs/signed/*"},
+			Keys:  []SigningKey{{ID: "key", Secret: "0123456789abcdef"}},
+		},
+		WebDAV: WebDAVSettings{Enabled: true},
+	})
+	assert.NoError(t, err)
+
+	serve := func(method, uri, depth string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(method, uri, strings.NewReader(propfindAllProps))
+			rr     = httptest.NewRecorder()
+		)
+
+		if depth != "" {
+			req.Header.Set("Depth", depth)
+		}
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := serve(methodPropfind, "/dav/assets/", "1")
+	assert.Equal(t, http.StatusMultiStatus, rr.Code)
+
+	hrefs := regexp.MustCompile(`<D:href>([^<]+)</D:href>`).FindAllStringSubmatch(rr.Body.String(), -1)
+	assert.Len(t, hrefs, 4)
+
+	for i, want := range []string{
+		"/dav/assets/", "/dav/assets/font.woff", "/dav/assets/icon.svg", "/dav/assets/logo.svg",
+	} {
+		assert.Equal(t, want, hrefs[i][1])
+	}
+
+	assert.Contains(t, rr.Body.String(), "<D:getcontentlength>12</D:getcontentlength>", "overlay file wins")
+
+	rr = serve(methodPropfind, "/dav/", "1")
+	assert.Equal(t, http.StatusMultiStatus, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "_headers", "service files are hidden")
+
+	assert.Equal(t, http.StatusMultiStatus, serve(methodPropfind, "/dav/assets/logo.svg", "0").Code)
+	assert.Equal(t, http.StatusNotFound, serve(methodPropfind, "/dav/missing", "0").Code)
+	assert.Equal(t, http.StatusNotFound, serve(methodPropfind, "/dav/_headers", "0").Code)
+	assert.Equal(t, http.StatusForbidden, serve(methodPropfind, "/dav/", "infinity").Code)
+	assert.Equal(t, http.StatusForbidden, serve(methodPropfind, "/dav/", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(methodPropfind, "/dav/private/doc.txt", "0").Code)
+
+	// collections are checked with and without the trailing slash, members are checked too
+	for _, tt := range []struct {
+		uri, depth string
+		wantCode   int
+	}{
+		{uri: "/dav/docs/private", depth: "0", wantCode: http.StatusUnauthorized},
+		{uri: "/dav/docs/private", depth: "1", wantCode: http.StatusUnauthorized},
+		{uri: "/dav/docs/./private/", depth: "0", wantCode: http.StatusUnauthorized},
+		{uri: "/dav/docs/", depth: "1", wantCode: http.StatusUnauthorized},
+		{uri: "/dav/docs/", depth: "0", wantCode: http.StatusMultiStatus},
+		{uri: "/dav/docs/signed", depth: "0", wantCode: http.StatusForbidden},
+		{uri: "/dav/docs/signed/b.txt", depth: "0", wantCode: http.StatusForbidden},
+	} {
+		assert.Equal(t, tt.wantCode, serve(methodPropfind, tt.uri, tt.depth).Code, tt.uri)
+	}
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(methodMkcol, "/dav/new", "").Code)
+	assert.Equal(t, "overlay logo", serve(http.MethodGet, "/dav/assets/logo.svg", "").Body.String())
+
+	rr = serve(http.MethodOptions, "/dav/", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("DAV"))
+	assert.Equal(t, "GET, OPTIONS, PROPFIND", rr.Header().Get("Allow"))
+}
+
+func TestFileServer_ServeHTTP_WebDAVWritable(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "assets", "icons"), 0700))
+
+	for name, content := range map[string]string{
+		"assets/logo.svg":        "logo",
+		"assets/icons/home.svg":  "home",
+		"assets/icons/README.md": "readme",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	settings := Settings{
+		FilesRoot:    tmpDir,
+		CacheEnabled: true,
+		Write: WriteSettings{
+			Enabled:       true,
+			Deny:          []string{"*/README.md"},
+			Delete:        true,
+			Authenticator: staticAuthenticator{},
+		},
+		WebDAV: WebDAVSettings{Enabled: true},
+	}
+
+	fs, err := NewFileServer(settings)
+	assert.NoError(t, err)
+
+	serve := func(method, uri, body string, header map[string]string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(method, uri, strings.NewReader(body))
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("X-User", "designer")
+
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	// put file content into the cache
+	assert.Equal(t, "logo", serve(http.MethodGet, "/assets/logo.svg", "", nil).Body.String())
+
+	rr := serve(http.MethodOptions, "/", "", nil)
+	assert.Equal(t, "1, 2", rr.Header().Get("DAV"))
+
+	rr = serve(http.MethodPut, "/assets/new.svg", "new", map[string]string{"X-User": ""})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "write authentication is required")
+
+	assert.Equal(t, http.StatusCreated, serve(http.MethodPut, "/assets/new.svg", "new", nil).Code)
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPut, "/assets/new.svg", "newer", nil).Code)
+	assert.Equal(t, http.StatusCreated, serve(methodMkcol, "/assets/fonts", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPut, "/assets/README.md", "", nil).Code)
+
+	// locking
+	rr = serve(methodLock, "/assets/logo.svg", `<?xml version="1.0" encoding="utf-8"?>
+<lockinfo xmlns="DAV:"><lockscope><exclusive/></lockscope><locktype><write/></locktype></lockinfo>`, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	token := rr.Header().Get("Lock-Token")
+	assert.NotEmpty(t, token)
+
+	rr = serve(methodMove, "/assets/logo.svg", "", map[string]string{"Destination": "/assets/brand.svg"})
+	assert.Equal(t, http.StatusLocked, rr.Code)
+
+	assert.Equal(t, http.StatusNoContent, serve(methodUnlock, "/assets/logo.svg", "",
+		map[string]string{"Lock-Token": token}).Code)
+
+	// moving and copying
+	rr = serve(methodMove, "/assets/logo.svg", "", map[string]string{"Destination": "http://example.com/assets/brand.svg"})
+	assert.Equal(t, http.StatusBadGateway, rr.Code, "another host")
+
+	rr = serve(methodMove, "/assets/logo.svg", "", map[string]string{"Destination": "/assets/brand.svg"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/assets/logo.svg", "", nil).Code,
+		"cached content is invalidated")
+	assert.Equal(t, "logo", serve(http.MethodGet, "/assets/brand.svg", "", nil).Body.String())
+
+	rr = serve(methodCopy, "/assets/icons", "", map[string]string{"Destination": "/icons"})
+	assert.Equal(t, http.StatusForbidden, rr.Code, "denied path can not be written")
+
+	rr = serve(methodCopy, "/assets/brand.svg", "", map[string]string{"Destination": "/brand.svg"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	// existing files are not replaced (overwriting is disabled)
+	for _, method := range []string{methodCopy, methodMove} {
+		rr = serve(method, "/assets/new.svg", "", map[string]string{"Destination": "/brand.svg", "Overwrite": "T"})
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code, method)
+	}
+
+	assert.Equal(t, "logo", serve(http.MethodGet, "/brand.svg", "", nil).Body.String())
+
+	// removing
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "/assets/icons/README.md", "", nil).Code,
+		"denied path")
+	assert.NoError(t, os.Remove(filepath.Join(tmpDir, "assets", "icons", "README.md")))
+	assert.Equal(t, http.StatusConflict, serve(http.MethodDelete, "/assets/icons", "", nil).Code,
+		"non-empty directory is not removed")
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/assets/icons/home.svg", "", nil).Code)
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/assets/icons", "", nil).Code)
+	assert.Equal(t, http.StatusMultiStatus, serve(methodProppatch, "/assets/new.svg", `<?xml version="1.0"?>
+<propertyupdate xmlns="DAV:"><set><prop><color xmlns="urn:x">red</color></prop></set></propertyupdate>`, nil).Code)
+
+	for name, exists := range map[string]bool{
+		"assets/new.svg":   true,
+		"assets/fonts":     true,
+		"assets/logo.svg":  false,
+		"assets/brand.svg": true,
+		"brand.svg":        true,
+		"assets/icons":     false,
+	} {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		assert.Equal(t, exists, err == nil, name)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(tmpDir, "assets"))
+	assert.Len(t, files, 3, "temporary files are removed")
+
+	// removing is disabled
+	settings.Write.Delete = false
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodDelete, "/assets/fonts", "", nil).Code)
+	assert.DirExists(t, filepath.Join(tmpDir, "assets", "fonts"))
+
+	// read-only WebDAV in write mode
+	settings.WebDAV.ReadOnly = true
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	rr = serve(methodMove, "/assets/brand.svg", "", map[string]string{"Destination": "/assets/logo.svg"})
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, http.StatusCreated, serve(http.MethodPut, "/assets/logo.svg", "logo", nil).Code,
+		"write mode is used")
+}
+
+func TestFileServer_ServeHTTP_WebDAVCopySourceAccess(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	for _, dir := range []string{"root/private", "root/pub", "root/docs", "layer/docs/private"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, dir), 0700))
+	}
+
+	for name, content := range map[string]string{
+		"root/private/secret.txt":       "secret",
+		"root/docs/readme.txt":          "readme",
+		"layer/docs/private/secret.txt": "layer secret",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+	}
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot: filepath.Join(tmpDir, "root"),
+		Layers:    []http.FileSystem{http.Dir(filepath.Join(tmpDir, "layer"))},
+		AuthRules: []AuthRule{
+			{Path: "/private/*", Authenticator: staticAuthenticator{}, Users: []string{"admin"}},
+			{Path: "/docs/private/*", Authenticator: staticAuthenticator{}, Users: []string{"admin"}},
+		},
+		Write: WriteSettings{
+			Enabled:       true,
+			Paths:         []string{"/pub/*"},
+			Authenticator: staticAuthenticator{},
+			Users:         []string{"ci", "admin"},
+		},
+		WebDAV: WebDAVSettings{Enabled: true},
+	})
+	assert.NoError(t, err)
+
+	serve := func(method, uri, user, destination string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(method, uri, nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("X-User", user)
+		req.Header.Set("Destination", destination)
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/private/secret.txt", "ci", "").Code)
+
+	for _, tt := range []struct {
+		method, uri, destination string
+	}{
+		{methodCopy, "/private/secret.txt", "/pub/leak.txt"},
+		{methodCopy, "/./private/secret.txt", "/pub/leak.txt"},
+		{methodCopy, "/private", "/pub/private"},
+		{methodCopy, "/docs", "/pub/docs"}, // protected file in the layer
+		{methodMove, "/private/secret.txt", "/pub/leak.txt"},
+	} {
+		assert.Equal(t, http.StatusForbidden, serve(tt.method, tt.uri, "ci", tt.destination).Code, tt.uri)
+	}
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/pub/leak.txt", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/pub/docs/readme.txt", "", "").Code)
+
+	assert.Equal(t, http.StatusCreated, serve(methodCopy, "/docs", "admin", "/pub/docs").Code)
+	assert.Equal(t, "layer secret", serve(http.MethodGet, "/pub/docs/private/secret.txt", "", "").Body.String())
+}
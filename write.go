@@ -0,0 +1,721 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/avto-dev/go-simple-fileserver/cache"
+)
+
+const (
+	defaultWriteMaxFileSize = 32 * 1024 * 1024 // 32 MiB
+
+	writtenFileMode os.FileMode = 0644
+	createdDirMode  os.FileMode = 0755
+
+	// uploadTempFilePattern is a temporary file name pattern (files are written into the target directory and then
+	// renamed, so partially written files are never served under the target name).
+	uploadTempFilePattern = ".upload-*.tmp"
+)
+
+// Write errors.
+var (
+	errWriteConflict = errors.New("conflict with the existing file or directory") //nolint:gochecknoglobals
+	errFileTooLarge  = errors.New("file is too large")                            //nolint:gochecknoglobals
+	errPathLocked    = errors.New("path is locked by another request")            //nolint:gochecknoglobals
+)
+
+// WriteSettings describes write mode options. In write mode `PUT` requests write the request body into the file,
+// `POST` requests save files from the multipart form (`multipart/form-data`) into the directory and `MKCOL` requests
+// create directories (`DELETE` requests must be enabled explicitly). Files are written into the FilesRoot directory
+// only (layers are read-only), URL rewriting rules are not applied to the write requests. Concurrent operations on
+// the same path are rejected with "423 Locked".
+type WriteSettings struct {
+	// Enables write mode (write methods are allowed automatically, `AllowedHTTPMethods` should contain read methods
+	// only).
+	Enabled bool
+
+	// Writable path patterns (relative to the `Settings.BasePath`): regular expression (when it starts with `^`) or
+	// glob (`*` matches any characters sequence, including `/`). Any path is writable, when empty.
+	Paths []string
+
+	// Path patterns (in the same format), that are never writable (have priority over the Paths).
+	Deny []string
+
+	// Enables `DELETE` requests for the files and empty directories.
+	Delete bool
+
+	// Maximal written file size in bytes (32 MiB by default). Larger files are rejected with "413 Request Entity Too
+	// Large".
+	MaxFileSize int64
+
+	// Allows existing files replacing ("409 Conflict" is responded otherwise, "412 Precondition Failed" for the WebDAV
+	// `COPY` and `MOVE` requests).
+	Overwrite bool
+
+	// Authenticator for the write requests (is required in write mode, `AuthRules` are not applied to the write
+	// requests).
+	Authenticator Authenticator
+
+	// Users, that are allowed to write (any authenticated user is allowed, when empty).
+	Users []string
+}
+
+// clone returns deep copy of the write settings.
+func (s WriteSettings) clone() WriteSettings {
+	s.Paths = append([]string(nil), s.Paths...)
+	s.Deny = append([]string(nil), s.Deny...)
+	s.Users = append([]string(nil), s.Users...)
+
+	return s
+}
+
+// validate checks write settings.
+func (s WriteSettings) validate(errs *ValidationErrors) {
+	for i, pattern := range s.Paths {
+		if _, err := compilePathPattern(pattern); err != nil {
+			errs.add(fmt.Sprintf("Write.Paths[%d]", i), "wrong pattern: %s", err)
+		}
+	}
+
+	for i, pattern := range s.Deny {
+		if _, err := compilePathPattern(pattern); err != nil {
+			errs.add(fmt.Sprintf("Write.Deny[%d]", i), "wrong pattern: %s", err)
+		}
+	}
+
+	if s.MaxFileSize < 0 {
+		errs.add("Write.MaxFileSize", "must not be negative")
+	}
+
+	if s.Enabled && s.Authenticator == nil {
+		errs.add("Write.Authenticator", "is required in write mode")
+	}
+}
+
+// withDefaults returns settings with default values for the empty fields.
+func (s WriteSettings) withDefaults() WriteSettings {
+	if s.MaxFileSize == 0 {
+		s.MaxFileSize = defaultWriteMaxFileSize
+	}
+
+	return s
+}
+
+// methodMkcol is a WebDAV directory creation method (RFC 4918).
+const methodMkcol = "MKCOL"
+
+// isWriteOnlyMethod checks that HTTP method has no read semantics (`POST` requests are served as reads, when write
+// mode is disabled).
+func isWriteOnlyMethod(method string) bool {
+	return method == http.MethodPut || method == http.MethodDelete || method == methodMkcol
+}
+
+// fileWriter contains prepared write mode settings.
+type fileWriter struct {
+	root        string
+	methods     []string // handled HTTP methods
+	paths       []*regexp.Regexp
+	deny        []*regexp.Regexp
+	auth        *authRule
+	maxFileSize int64
+	overwrite   bool
+}
+
+// newFileWriter prepares (already validated) write settings. Nil is returned, when write mode is disabled.
+func newFileWriter(s Settings) *fileWriter {
+	if !s.Write.Enabled {
+		return nil
+	}
+
+	wr := &fileWriter{
+		root:        s.FilesRoot,
+		methods:     []string{http.MethodPut, http.MethodPost, methodMkcol},
+		paths:       compilePathPatterns(s.Write.Paths),
+		deny:        compilePathPatterns(s.Write.Deny),
+		maxFileSize: s.Write.MaxFileSize,
+		overwrite:   s.Write.Overwrite,
+	}
+
+	if s.Write.Delete {
+		wr.methods = append(wr.methods, http.MethodDelete)
+	}
+
+	wr.auth, _ = compileAuthRule(AuthRule{Path: "*", Authenticator: s.Write.Authenticator, Users: s.Write.Users})
+
+	return wr
+}
+
+// compilePathPatterns compiles (already validated) path patterns. Wrong patterns are skipped.
+func compilePathPatterns(patterns []string) []*regexp.Regexp {
+	result := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		if re, err := compilePathPattern(pattern); err == nil {
+			result = append(result, re)
+		}
+	}
+
+	return result
+}
+
+// matchesAny checks that URL path matches any of the patterns.
+func matchesAny(patterns []*regexp.Regexp, urlPath string) bool {
+	for _, re := range patterns {
+		if re.MatchString(urlPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handles checks that HTTP method is handled by the writer (false is returned for the nil writer).
+func (wr *fileWriter) handles(method string) bool {
+	if wr == nil {
+		return false
+	}
+
+	for _, m := range wr.methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// target returns file system path for the (cleaned) URL path. Non-zero HTTP status code is returned, when the path is
+// not writable.
+func (wr *fileWriter) target(cfg *config, urlPath string) (string, int) {
+	if urlPath == "/" || cfg.isHiddenPath(urlPath) || matchesAny(wr.deny, urlPath) ||
+		(len(wr.paths) > 0 && !matchesAny(wr.paths, urlPath)) {
+		return "", http.StatusForbidden
+	}
+
+	filePath := filepath.Join(wr.root, filepath.FromSlash(urlPath))
+
+	if !wr.confined(filePath) {
+		return "", http.StatusForbidden
+	}
+
+	return filePath, 0
+}
+
+// confined checks that the nearest existing parent directory of the file path (with all symbolic links resolved) is
+// located inside the root directory.
+func (wr *fileWriter) confined(filePath string) bool {
+	root, err := filepath.EvalSymlinks(wr.root)
+	if err != nil {
+		return false
+	}
+
+	dir := filepath.Dir(filePath)
+
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			rel, err := filepath.Rel(root, resolved)
+
+			return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+		}
+
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			return false
+		}
+
+		dir = parent
+	}
+}
+
+// writeFile locks the file path and writes the content into the file (see fileWriter.writeFile).
+func (fs *FileServer) writeFile(cfg *config, filePath string, content io.Reader) (bool, error) {
+	if !fs.writeLocks.tryLock(filePath) {
+		return false, errPathLocked
+	}
+
+	defer fs.writeLocks.unlock(filePath)
+
+	return cfg.writer.writeFile(filePath, content)
+}
+
+// writeFile atomically writes the content into the file (missing parent directories are created). True is returned,
+// when new file was created.
+func (wr *fileWriter) writeFile(filePath string, content io.Reader) (bool, error) {
+	f, created, err := wr.createFile(filePath, true)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = io.Copy(f, content); err != nil {
+		f.discard()
+
+		return false, err
+	}
+
+	return created, f.commit()
+}
+
+// createFile checks the target file path (and overwrite policy) and creates temporary file for the atomic writing.
+// True is returned, when the target file does not exist.
+func (wr *fileWriter) createFile(filePath string, createParents bool) (*atomicFile, bool, error) {
+	created, err := wr.prepareTarget(filePath, createParents)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), uploadTempFilePattern)
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return nil, false, errWriteConflict
+		}
+
+		return nil, false, err
+	}
+
+	return &atomicFile{file: tmp, target: filePath, maxSize: wr.maxFileSize}, created, nil
+}
+
+// prepareTarget checks the target file path (and overwrite policy) and creates missing parent directories (when
+// createParents is true). True is returned, when the target file does not exist.
+func (wr *fileWriter) prepareTarget(filePath string, createParents bool) (bool, error) {
+	info, err := os.Stat(filePath)
+
+	switch {
+	case err == nil && !info.Mode().IsRegular(), err == nil && !wr.overwrite:
+		return false, errWriteConflict
+
+	case err != nil && errors.Is(err, syscall.ENOTDIR):
+		return false, errWriteConflict
+
+	case err != nil && !os.IsNotExist(err):
+		return false, err
+	}
+
+	if createParents {
+		if err := os.MkdirAll(filepath.Dir(filePath), createdDirMode); err != nil {
+			if errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.EEXIST) {
+				return false, errWriteConflict
+			}
+
+			return false, err
+		}
+	}
+
+	return err != nil, nil
+}
+
+// moveFile moves the file into the target path (file content is copied into the temporary file first, when the
+// files are located on different devices). True is returned, when new file was created.
+func (wr *fileWriter) moveFile(srcPath, filePath string) (bool, error) {
+	created, err := wr.prepareTarget(filePath, true)
+	if err != nil {
+		return false, err
+	}
+
+	if err = os.Chmod(srcPath, writtenFileMode); err != nil {
+		return false, err
+	}
+
+	if err = os.Rename(srcPath, filePath); err == nil {
+		return created, nil
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return false, err
+	}
+
+	defer src.Close()
+
+	if created, err = wr.writeFile(filePath, src); err != nil {
+		return false, err
+	}
+
+	return created, os.Remove(srcPath)
+}
+
+// atomicFile is a temporary file, that replaces the target file on commit. It does not implement io.ReaderFrom
+// interface, so the size limit can not be bypassed.
+type atomicFile struct {
+	file    *os.File
+	target  string
+	maxSize int64
+	size    int64
+	failed  bool // write error occurred
+}
+
+// Write implements io.Writer interface. errFileTooLarge is returned, when the size limit is exceeded.
+func (f *atomicFile) Write(b []byte) (int, error) {
+	if f.size+int64(len(b)) > f.maxSize {
+		f.failed = true
+
+		return 0, errFileTooLarge
+	}
+
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+
+	if err != nil {
+		f.failed = true
+	}
+
+	return n, err
+}
+
+// commit flushes written data and renames temporary file to the target path. Temporary file is removed on error.
+func (f *atomicFile) commit() (err error) {
+	defer func() {
+		if err != nil {
+			f.discard()
+		}
+	}()
+
+	if f.failed {
+		return errors.New("file was not written completely")
+	}
+
+	if err = f.file.Sync(); err != nil {
+		return err
+	}
+
+	if err = f.file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Chmod(f.file.Name(), writtenFileMode); err != nil {
+		return err
+	}
+
+	return os.Rename(f.file.Name(), f.target)
+}
+
+// discard closes and removes temporary file.
+func (f *atomicFile) discard() {
+	_ = f.file.Close()
+	_ = os.Remove(f.file.Name())
+}
+
+// pathLocks tracks file system paths, that are being changed by the write requests (zero value is ready to use).
+type pathLocks struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+}
+
+// tryLock locks the path. False is returned, when the path is already locked.
+func (l *pathLocks) tryLock(filePath string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, locked := l.paths[filePath]; locked {
+		return false
+	}
+
+	if l.paths == nil {
+		l.paths = make(map[string]struct{})
+	}
+
+	l.paths[filePath] = struct{}{}
+
+	return true
+}
+
+// unlock unlocks the path.
+func (l *pathLocks) unlock(filePath string) {
+	l.mu.Lock()
+	delete(l.paths, filePath)
+	l.mu.Unlock()
+}
+
+// writeErrorStatus returns HTTP status code for the write error.
+func writeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errWriteConflict):
+		return http.StatusConflict
+
+	case errors.Is(err, errFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+
+	case errors.Is(err, errPathLocked):
+		return http.StatusLocked
+
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+// invalidateCache removes cached content of the FilesRoot file (when cacher supports items deletion).
+func (fs *FileServer) invalidateCache(cfg *config, urlPath string) {
+	if fs.Cache == nil || len(cfg.layers) == 0 {
+		return
+	}
+
+	if deleter, ok := fs.Cache.(cache.Deleter); ok {
+		root := cfg.layers[len(cfg.layers)-1]
+
+		deleter.Delete(cfg.cacheKey(root.key(path.Clean("/" + urlPath))))
+	}
+}
+
+// serveWrite handles write request for the URL path (relative to the base path).
+func (fs *FileServer) serveWrite(w http.ResponseWriter, r *http.Request, cfg *config, urlPath string) {
+	r, authorized := fs.checkAuthRule(w, r, cfg.writer.auth)
+	if !authorized {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		fs.servePut(w, r, cfg, urlPath)
+
+	case http.MethodPost:
+		fs.servePost(w, r, cfg, urlPath)
+
+	case http.MethodDelete:
+		fs.serveDelete(w, r, cfg, urlPath)
+
+	case methodMkcol:
+		fs.serveMkcol(w, r, cfg, urlPath)
+	}
+}
+
+// servePut writes the request body into the file. "201 Created" is responded for the new files, "204 No Content" for
+// the replaced ones.
+func (fs *FileServer) servePut(w http.ResponseWriter, r *http.Request, cfg *config, urlPath string) {
+	if strings.HasSuffix(urlPath, "/") {
+		fs.handleError(w, r, http.StatusConflict)
+
+		return
+	}
+
+	urlPath = path.Clean(urlPath)
+
+	filePath, status := cfg.writer.target(cfg, urlPath)
+	if status != 0 {
+		fs.handleError(w, r, status)
+
+		return
+	}
+
+	if r.ContentLength > cfg.writer.maxFileSize {
+		fs.handleError(w, r, http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	created, err := fs.writeFile(cfg, filePath, r.Body)
+	if err != nil {
+		fs.handleError(w, r, writeErrorStatus(err))
+
+		return
+	}
+
+	fs.invalidateCache(cfg, urlPath)
+
+	if created {
+		w.Header().Set("Location", cfg.settings.BasePath+urlPath)
+		w.WriteHeader(http.StatusCreated)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadResult is a response body for the multipart uploads.
+type uploadResult struct {
+	Files []string `json:"files"` // URL paths of the saved files
+}
+
+// servePost saves files from the multipart form into the directory (form fields without file names are ignored).
+// Files are saved in order, so files before the failed one stay saved. "201 Created" with the saved files list (JSON)
+// is responded on success.
+func (fs *FileServer) servePost(w http.ResponseWriter, r *http.Request, cfg *config, urlPath string) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		if errors.Is(err, http.ErrNotMultipart) {
+			fs.handleError(w, r, http.StatusUnsupportedMediaType)
+		} else {
+			fs.handleError(w, r, http.StatusBadRequest)
+		}
+
+		return
+	}
+
+	var (
+		dirPath = path.Clean(urlPath)
+		result  = uploadResult{Files: make([]string, 0)}
+	)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			fs.handleError(w, r, http.StatusBadRequest)
+
+			return
+		}
+
+		if part.FileName() == "" {
+			_ = part.Close()
+
+			continue
+		}
+
+		filePath, status := fs.saveUploadedFile(cfg, dirPath, part)
+		if status != 0 {
+			fs.handleError(w, r, status)
+
+			return
+		}
+
+		result.Files = append(result.Files, cfg.settings.BasePath+filePath)
+	}
+
+	if len(result.Files) == 0 {
+		fs.handleError(w, r, http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// saveUploadedFile saves the multipart form file into the directory. Saved file URL path or non-zero HTTP status code
+// (on error) is returned.
+func (fs *FileServer) saveUploadedFile(cfg *config, dirPath string, part *multipart.Part) (string, int) {
+	defer part.Close()
+
+	// only base name of the client file name is used (windows paths are supported too)
+	name := path.Base(strings.ReplaceAll(part.FileName(), `\`, "/"))
+	if name == "." || name == ".." || name == "/" {
+		return "", http.StatusBadRequest
+	}
+
+	urlPath := path.Join(dirPath, name)
+
+	filePath, status := cfg.writer.target(cfg, urlPath)
+	if status != 0 {
+		return "", status
+	}
+
+	if _, err := fs.writeFile(cfg, filePath, part); err != nil {
+		return "", writeErrorStatus(err)
+	}
+
+	fs.invalidateCache(cfg, urlPath)
+
+	return urlPath, 0
+}
+
+// serveDelete removes the file or empty directory. "204 No Content" is responded on success, "409 Conflict" for the
+// non-empty directories.
+func (fs *FileServer) serveDelete(w http.ResponseWriter, r *http.Request, cfg *config, urlPath string) {
+	urlPath = path.Clean(urlPath)
+
+	filePath, status := cfg.writer.target(cfg, urlPath)
+	if status != 0 {
+		fs.handleError(w, r, status)
+
+		return
+	}
+
+	if !fs.writeLocks.tryLock(filePath) {
+		fs.handleError(w, r, http.StatusLocked)
+
+		return
+	}
+
+	defer fs.writeLocks.unlock(filePath)
+
+	if _, err := os.Lstat(filePath); err != nil {
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			fs.handleError(w, r, http.StatusNotFound)
+		} else {
+			fs.handleError(w, r, writeErrorStatus(err))
+		}
+
+		return
+	}
+
+	if err := os.Remove(filePath); err != nil {
+		if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+			fs.handleError(w, r, http.StatusConflict)
+		} else {
+			fs.handleError(w, r, writeErrorStatus(err))
+		}
+
+		return
+	}
+
+	fs.invalidateCache(cfg, urlPath)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveMkcol creates the directory (parent directory must exist). "201 Created" is responded on success, "405 Method
+// Not Allowed" for the existing paths and "409 Conflict" for the missing parent directories (like RFC 4918 requires).
+func (fs *FileServer) serveMkcol(w http.ResponseWriter, r *http.Request, cfg *config, urlPath string) {
+	if r.ContentLength > 0 { // request body is not supported
+		fs.handleError(w, r, http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	urlPath = path.Clean(urlPath)
+
+	dirPath, status := cfg.writer.target(cfg, urlPath)
+	if status != 0 {
+		fs.handleError(w, r, status)
+
+		return
+	}
+
+	if !fs.writeLocks.tryLock(dirPath) {
+		fs.handleError(w, r, http.StatusLocked)
+
+		return
+	}
+
+	defer fs.writeLocks.unlock(dirPath)
+
+	if err := os.Mkdir(dirPath, createdDirMode); err != nil {
+		switch {
+		case os.IsExist(err):
+			w.Header().Set("Allow", cfg.allowHeader)
+			fs.handleError(w, r, http.StatusMethodNotAllowed)
+
+		case os.IsNotExist(err), errors.Is(err, syscall.ENOTDIR):
+			fs.handleError(w, r, http.StatusConflict)
+
+		default:
+			fs.handleError(w, r, writeErrorStatus(err))
+		}
+
+		return
+	}
+
+	w.Header().Set("Location", cfg.settings.BasePath+urlPath+"/")
+	w.WriteHeader(http.StatusCreated)
+}
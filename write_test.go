@@ -0,0 +1,310 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileServer_ServeHTTP_Put(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "artifacts"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "artifacts", "app.js"), []byte("v1"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "_redirects"), nil, 0600))
+
+	settings := Settings{
+		FilesRoot:         tmpDir,
+		BasePath:          "/files",
+		RedirectsFileName: "_redirects",
+		CacheEnabled:      true,
+		Write: WriteSettings{
+			Enabled:       true,
+			Paths:         []string{"/artifacts/*", "/_redirects"},
+			MaxFileSize:   8,
+			Authenticator: staticAuthenticator{},
+			Users:         []string{"ci"},
+		},
+	}
+
+	fs, err := NewFileServer(settings)
+	assert.NoError(t, err)
+
+	serve := func(method, uri, user, body string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(method, uri, strings.NewReader(body))
+			rr     = httptest.NewRecorder()
+		)
+
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	// put file content into the cache
+	assert.Equal(t, "v1", serve(http.MethodGet, "/files/artifacts/app.js", "", "").Body.String())
+
+	for _, tt := range []struct {
+		name     string
+		uri      string
+		user     string
+		body     string
+		wantCode int
+	}{
+		{name: "anonymous", uri: "/files/artifacts/new.txt", body: "new", wantCode: http.StatusUnauthorized},
+		{name: "not allowed user", uri: "/files/artifacts/new.txt", user: "guest", wantCode: http.StatusForbidden},
+		{name: "not writable path", uri: "/files/index.html", user: "ci", wantCode: http.StatusForbidden},
+		{name: "hidden file", uri: "/files/_redirects", user: "ci", wantCode: http.StatusForbidden},
+		{name: "directory", uri: "/files/artifacts/", user: "ci", wantCode: http.StatusConflict},
+		{name: "existing file", uri: "/files/artifacts/app.js", user: "ci", body: "v2", wantCode: http.StatusConflict},
+		{name: "too large", uri: "/files/artifacts/big.bin", user: "ci", body: "123456789", wantCode: 413},
+		{name: "new file", uri: "/files/artifacts/v1/new.txt", user: "ci", body: "new", wantCode: http.StatusCreated},
+		{name: "parent is a file", uri: "/files/artifacts/app.js/x", user: "ci", wantCode: http.StatusConflict},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantCode, serve(http.MethodPut, tt.uri, tt.user, tt.body).Code)
+		})
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(tmpDir, "artifacts", "v1", "new.txt"))
+	assert.Equal(t, "new", string(content))
+
+	_, err = os.Stat(filepath.Join(tmpDir, "artifacts", "big.bin"))
+	assert.True(t, os.IsNotExist(err), "too large file is not saved")
+
+	files, _ := ioutil.ReadDir(filepath.Join(tmpDir, "artifacts"))
+	assert.Len(t, files, 2, "temporary files are removed")
+
+	// overwriting invalidates cached content
+	settings.Write.Overwrite = true
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	rr := serve(http.MethodPut, "/files/artifacts/app.js", "ci", "v2")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "v2", serve(http.MethodGet, "/files/artifacts/app.js", "", "").Body.String())
+
+	rr = serve(http.MethodOptions, "/files/", "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "GET, PUT, POST, MKCOL", rr.Header().Get("Allow"), "write methods are allowed automatically")
+
+	// write mode disabling
+	settings.Write.Enabled = false
+	settings.AllowedHTTPMethods = []string{http.MethodGet, http.MethodPut}
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPut, "/files/artifacts/app.js", "ci", "v3").Code)
+}
+
+func TestFileServer_ServeHTTP_Post(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "index.html"), []byte("index"), 0600))
+
+	fs, err := NewFileServer(Settings{
+		FilesRoot: tmpDir,
+		Write:     WriteSettings{Enabled: true, Authenticator: staticAuthenticator{}},
+	})
+	assert.NoError(t, err)
+
+	serve := func(uri, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(http.MethodPost, uri, body)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("X-User", "ci")
+		req.Header.Set("Content-Type", contentType)
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	form := func(files map[string]string) (string, io.Reader) {
+		var (
+			buf bytes.Buffer
+			mw  = multipart.NewWriter(&buf)
+		)
+
+		assert.NoError(t, mw.WriteField("comment", "build #1"))
+
+		for name, content := range files {
+			fw, _ := mw.CreateFormFile("file", name)
+			_, _ = fw.Write([]byte(content))
+		}
+
+		assert.NoError(t, mw.Close())
+
+		return mw.FormDataContentType(), &buf
+	}
+
+	post := func(uri string, files map[string]string) *httptest.ResponseRecorder {
+		contentType, body := form(files)
+
+		return serve(uri, contentType, body)
+	}
+
+	rr := post("/", map[string]string{"a.txt": "a", `C:\build\b.txt`: "b"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"/b.txt"`)
+
+	for name, content := range map[string]string{"a.txt": "a", "b.txt": "b"} {
+		data, _ := ioutil.ReadFile(filepath.Join(tmpDir, name))
+		assert.Equal(t, content, string(data))
+	}
+
+	rr = post("/releases/v1/", map[string]string{"../../d.txt": "d"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, `{"files":["/releases/v1/d.txt"]}`+"\n", rr.Body.String(), "only base name is used")
+
+	assert.Equal(t, http.StatusConflict, post("/", map[string]string{"index.html": "new"}).Code)
+	assert.Equal(t, http.StatusConflict, post("/index.html", map[string]string{"x.txt": "x"}).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve("/", "text/plain", strings.NewReader("text")).Code)
+	assert.Equal(t, http.StatusBadRequest, serve("/", "multipart/form-data", strings.NewReader("text")).Code)
+	assert.Equal(t, http.StatusBadRequest, post("/", nil).Code, "files are required")
+}
+
+func TestFileServer_ServeHTTP_DeleteAndMkcol(t *testing.T) { //nolint:funlen
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "artifacts", "v1"), 0700))
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "artifacts", "empty"), 0700))
+
+	for _, name := range []string{"artifacts/v1/app.js", "artifacts/keep.txt", "locked.txt"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0600))
+	}
+
+	settings := Settings{
+		FilesRoot:    tmpDir,
+		CacheEnabled: true,
+		Write: WriteSettings{
+			Enabled:       true,
+			Deny:          []string{"*/keep.txt"},
+			Authenticator: staticAuthenticator{},
+		},
+	}
+
+	fs, err := NewFileServer(settings)
+	assert.NoError(t, err)
+
+	serve := func(method, uri, user string) *httptest.ResponseRecorder {
+		var (
+			req, _ = http.NewRequest(method, uri, nil)
+			rr     = httptest.NewRecorder()
+		)
+
+		req.Header.Set("X-User", user)
+		req.Header.Set("Accept", "application/json")
+		fs.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodDelete, "/locked.txt", "ci").Code,
+		"DELETE requests must be enabled explicitly")
+
+	settings.Write.Delete = true
+	assert.NoError(t, fs.UpdateSettings(settings))
+
+	// put file content into the cache
+	assert.Equal(t, "artifacts/v1/app.js", serve(http.MethodGet, "/artifacts/v1/app.js", "").Body.String())
+
+	assert.True(t, fs.writeLocks.tryLock(filepath.Join(tmpDir, "locked.txt")))
+
+	for _, tt := range []struct {
+		method   string
+		uri      string
+		user     string
+		wantCode int
+	}{
+		{method: http.MethodDelete, uri: "/artifacts/v1/app.js", wantCode: http.StatusUnauthorized},
+		{method: http.MethodDelete, uri: "/artifacts/v1/app.js", user: "ci", wantCode: http.StatusNoContent},
+		{method: http.MethodDelete, uri: "/artifacts/v1/app.js", user: "ci", wantCode: http.StatusNotFound},
+		{method: http.MethodDelete, uri: "/artifacts/keep.txt", user: "ci", wantCode: http.StatusForbidden},
+		{method: http.MethodDelete, uri: "/artifacts/keep.txt/x", user: "ci", wantCode: http.StatusNotFound},
+		{method: http.MethodDelete, uri: "/artifacts", user: "ci", wantCode: http.StatusConflict},
+		{method: http.MethodDelete, uri: "/artifacts/empty/", user: "ci", wantCode: http.StatusNoContent},
+		{method: http.MethodDelete, uri: "/locked.txt", user: "ci", wantCode: http.StatusLocked},
+		{method: http.MethodDelete, uri: "/", user: "ci", wantCode: http.StatusForbidden},
+		{method: methodMkcol, uri: "/artifacts/v2", user: "ci", wantCode: http.StatusCreated},
+		{method: methodMkcol, uri: "/artifacts/v2/", user: "ci", wantCode: http.StatusMethodNotAllowed},
+		{method: methodMkcol, uri: "/missing/dir", user: "ci", wantCode: http.StatusConflict},
+		{method: methodMkcol, uri: "/locked.txt", user: "ci", wantCode: http.StatusLocked},
+		{method: http.MethodPut, uri: "/locked.txt", user: "ci", wantCode: http.StatusLocked},
+		{method: methodMkcol, uri: "/artifacts/keep.txt", user: "ci", wantCode: http.StatusForbidden},
+	} {
+		rr := serve(tt.method, tt.uri, tt.user)
+		assert.Equal(t, tt.wantCode, rr.Code, tt.method+" "+tt.uri)
+
+		if tt.wantCode >= http.StatusBadRequest {
+			assert.Contains(t, rr.Header().Get("Content-Type"), "json", "error handlers are used")
+		}
+	}
+
+	fs.writeLocks.unlock(filepath.Join(tmpDir, "locked.txt"))
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/artifacts/v1/app.js", "").Code,
+		"cached content is invalidated")
+
+	for name, exists := range map[string]bool{
+		"artifacts/v1":       true,
+		"artifacts/v2":       true,
+		"artifacts/empty":    false,
+		"artifacts/keep.txt": true,
+		"locked.txt":         true,
+	} {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		assert.Equal(t, exists, err == nil, name)
+	}
+}
+
+func TestFileWriter_Confined(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	root := filepath.Join(tmpDir, "root")
+	assert.NoError(t, os.Mkdir(root, 0700))
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpDir, "outside"), 0700))
+
+	if err := os.Symlink(filepath.Join(tmpDir, "outside"), filepath.Join(root, "link")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+
+	wr := &fileWriter{root: root}
+
+	assert.True(t, wr.confined(filepath.Join(root, "file")))
+	assert.True(t, wr.confined(filepath.Join(root, "missing", "dir", "file")))
+	assert.False(t, wr.confined(filepath.Join(root, "link", "file")))
+	assert.False(t, wr.confined(filepath.Join(root, "link", "missing", "file")))
+}
+
+func TestSettings_Validate_Write(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "test-")
+	defer func(d string) { assert.NoError(t, os.RemoveAll(d)) }(tmpDir)
+
+	err := Settings{FilesRoot: tmpDir, Write: WriteSettings{
+		Enabled:     true,
+		Paths:       []string{"/uploads/*", "^/("},
+		Deny:        []string{"^/("},
+		MaxFileSize: -1,
+	}}.Validate()
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Write.Paths[1]", "Write.Deny[0]", "Write.MaxFileSize", "Write.Authenticator"},
+		err.(ValidationErrors).Fields())
+}